@@ -0,0 +1,57 @@
+package jsonparse
+
+import (
+	"strings"
+)
+
+// aggregate computes {json.<func>.<path>} placeholders such as
+// {json.sum.items.*.amount}. Only paths with a "*" key are
+// aggregated, other keys are regular paths.
+func aggregate(v interface{}, key string) (interface{}, bool) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 || !hasWildcard(parts[1]) {
+		return nil, false
+	}
+
+	var values []interface{}
+	for _, val := range fetchValues(v, parts[1]) {
+		if val != missing {
+			values = append(values, val)
+		}
+	}
+
+	switch parts[0] {
+	case "count":
+		return len(values), true
+	case "sum":
+		var sum float64
+		for _, val := range values {
+			if n, ok := number(val); ok {
+				sum += n
+			}
+		}
+		return sum, true
+	case "min", "max":
+		var result interface{}
+		for _, val := range values {
+			n, ok := number(val)
+			if !ok {
+				continue
+			}
+			if m, ok := result.(float64); !ok || (parts[0] == "min" && n < m) || (parts[0] == "max" && n > m) {
+				result = n
+			}
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func hasWildcard(path string) bool {
+	for _, k := range strings.Split(path, ".") {
+		if k == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package jsonparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	const body = `{"items": [{"amount": 5}, {"amount": 2.5}, {"amount": "n/a"}, {}], "sum": {"x": 1}}`
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key      string
+		expected interface{}
+		ok       bool
+	}{
+		{key: "sum.items.*.amount", expected: 7.5, ok: true},
+		{key: "min.items.*.amount", expected: 2.5, ok: true},
+		{key: "max.items.*.amount", expected: float64(5), ok: true},
+		{key: "count.items.*.amount", expected: 3, ok: true},
+		{key: "count.items.*", expected: 4, ok: true},
+		{key: "max.missing.*", expected: nil, ok: true},
+		{key: "sum.x", ok: false},
+		{key: "avg.items.*.amount", ok: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			val, ok := aggregate(v, tt.key)
+			if ok != tt.ok {
+				t.Fatalf("want ok: %v, got: %v", tt.ok, ok)
+			}
+			if val != tt.expected {
+				t.Errorf("want: %v, got: %v", tt.expected, val)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package jsonparse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Restriction limits who may set a path in the body.
+type Restriction struct {
+	// Path is the path to the restricted value. A "*" key
+	// matches every element of an array or object.
+	Path string `json:"path,omitempty"`
+
+	// Roles lists the roles allowed to set the value.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// authorize returns an error if doc sets the restricted path
+// while none of roles is allowed to.
+func (res *Restriction) authorize(doc interface{}, roles []string) error {
+	for _, val := range fetchValues(doc, res.Path) {
+		if val == missing {
+			continue
+		}
+		for _, role := range roles {
+			if contains(res.Roles, role) {
+				return nil
+			}
+		}
+		return fmt.Errorf("not allowed to set %s", res.Path)
+	}
+	return nil
+}
+
+// splitRoles splits a list of roles separated by commas or spaces.
+func splitRoles(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,36 @@
+package jsonparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestRestriction(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{"price_override": null, "items": [{"discount": 5}]}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		restriction Restriction
+		roles       string
+		allowed     bool
+	}{
+		{restriction: Restriction{Path: "price_override", Roles: []string{"admin"}}, roles: "admin", allowed: true},
+		{restriction: Restriction{Path: "price_override", Roles: []string{"admin"}}, roles: "user", allowed: false},
+		{restriction: Restriction{Path: "price_override", Roles: []string{"admin"}}, roles: "", allowed: false},
+		{restriction: Restriction{Path: "items.*.discount", Roles: []string{"admin", "sales"}}, roles: "user, sales", allowed: true},
+		{restriction: Restriction{Path: "items.*.discount", Roles: []string{"admin"}}, roles: "user sales", allowed: false},
+		{restriction: Restriction{Path: "refund", Roles: []string{"admin"}}, roles: "user", allowed: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			err := tt.restriction.authorize(doc, splitRoles(tt.roles))
+			if (err == nil) != tt.allowed {
+				t.Errorf("want allowed: %v, got: %v", tt.allowed, err)
+			}
+		})
+	}
+}
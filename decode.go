@@ -0,0 +1,235 @@
+package jsonparse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Duplicate key policies.
+const (
+	duplicateKeepLast  = "keep_last"
+	duplicateKeepFirst = "keep_first"
+	duplicateReject    = "reject"
+)
+
+// duplicateKeyError is returned for repeated keys under the
+// reject policy. Unlike other parse errors, it always rejects
+// the request.
+type duplicateKeyError struct {
+	key string
+}
+
+func (e duplicateKeyError) Error() string { return fmt.Sprintf("duplicate key '%s'", e.key) }
+
+// parseOptions controls how request bodies are read and decoded.
+type parseOptions struct {
+	source           source
+	maxSize          int64
+	duplicateKeys    string
+	normalizeUnicode bool
+	stripInvisible   bool
+	strictNormalized bool
+	exactNumbers     bool
+	embedded         []string
+	strictEmbedded   bool
+	maxDepth         int
+	maxTokens        int
+	maxTime          time.Duration
+}
+
+// tokenized reports whether decoding requires walking the tokens
+// instead of the standard library's decoding.
+func (o parseOptions) tokenized() bool {
+	return (o.duplicateKeys != "" && o.duplicateKeys != duplicateKeepLast) ||
+		o.maxDepth > 0 || o.maxTokens > 0 || o.maxTime > 0 || o.normalized()
+}
+
+// equal reports whether o and p read and decode bodies alike.
+func (o parseOptions) equal(p parseOptions) bool {
+	if len(o.embedded) != len(p.embedded) {
+		return false
+	}
+	for i := range o.embedded {
+		if o.embedded[i] != p.embedded[i] {
+			return false
+		}
+	}
+	return o.source == p.source &&
+		o.maxSize == p.maxSize &&
+		o.duplicateKeys == p.duplicateKeys &&
+		o.normalizeUnicode == p.normalizeUnicode &&
+		o.stripInvisible == p.stripInvisible &&
+		o.strictNormalized == p.strictNormalized &&
+		o.exactNumbers == p.exactNumbers &&
+		o.strictEmbedded == p.strictEmbedded &&
+		o.maxDepth == p.maxDepth &&
+		o.maxTokens == p.maxTokens &&
+		o.maxTime == p.maxTime
+}
+
+// normalized reports whether keys and strings are normalized.
+func (o parseOptions) normalized() bool {
+	return o.normalizeUnicode || o.stripInvisible
+}
+
+// decoder walks the tokens of a json document within the
+// budgets of its options.
+type decoder struct {
+	*json.Decoder
+	ctx    context.Context
+	opts   parseOptions
+	tokens int
+}
+
+// Token returns the next token, failing once the token
+// budget is exceeded or ctx is done.
+func (d *decoder) Token() (json.Token, error) {
+	d.tokens++
+	if d.opts.maxTokens > 0 && d.tokens > d.opts.maxTokens {
+		return nil, fmt.Errorf("too many tokens, limit is %d", d.opts.maxTokens)
+	}
+	if err := d.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return d.Decoder.Token()
+}
+
+// decode parses data as a single json value.
+func decode(data []byte, opts parseOptions) (interface{}, error) {
+	return decodeContext(context.Background(), data, opts)
+}
+
+// decodeContext is like decode, but stops once ctx is done or
+// the processing time of opts is exceeded.
+func decodeContext(ctx context.Context, data []byte, opts parseOptions) (interface{}, error) {
+	if opts.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.maxTime)
+		defer cancel()
+	}
+
+	v, err := decodeTree(ctx, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range opts.embedded {
+		if err := parseEmbedded(ctx, v, path, opts); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func decodeTree(ctx context.Context, data []byte, opts parseOptions) (interface{}, error) {
+	var v interface{}
+	if !opts.tokenized() && !opts.exactNumbers {
+		err := json.Unmarshal(data, &v)
+		return v, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if opts.exactNumbers {
+		// numbers keep their literal text as json.Number
+		dec.UseNumber()
+	}
+
+	var err error
+	if opts.tokenized() {
+		v, err = (&decoder{Decoder: dec, ctx: ctx, opts: opts}).decodeValue(0)
+	} else {
+		err = dec.Decode(&v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return v, nil
+}
+
+func (dec *decoder) decodeValue(depth int) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if delim, ok := tok.(json.Delim); ok && (delim == '{' || delim == '[') {
+		if dec.opts.maxDepth > 0 && depth >= dec.opts.maxDepth {
+			return nil, fmt.Errorf("too deeply nested, limit is %d", dec.opts.maxDepth)
+		}
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		m := map[string]interface{}{}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			// keys are normalized before the duplicate check, so
+			// that keys colliding once normalized are duplicates.
+			key, err := dec.normalize(tok.(string))
+			if err != nil {
+				return nil, err
+			}
+
+			val, err := dec.decodeValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := m[key]; ok {
+				switch dec.opts.duplicateKeys {
+				case duplicateReject:
+					return nil, duplicateKeyError{key}
+				case duplicateKeepFirst:
+					continue
+				}
+			}
+			m[key] = val
+		}
+		_, err := dec.Token() // closing brace
+		return m, err
+
+	case json.Delim('['):
+		a := []interface{}{}
+		for dec.More() {
+			val, err := dec.decodeValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, val)
+		}
+		_, err := dec.Token() // closing bracket
+		return a, err
+	}
+
+	if s, ok := tok.(string); ok {
+		return dec.normalize(s)
+	}
+	return tok, nil
+}
+
+// normalize applies the unicode options to a key or string.
+// With strictNormalized, strings changed by normalization are an
+// error, as the forwarded body still contains the original.
+func (dec *decoder) normalize(s string) (string, error) {
+	if !dec.opts.normalized() {
+		return s, nil
+	}
+	n := normalizeString(s, dec.opts.stripInvisible)
+	if dec.opts.strictNormalized && n != s {
+		return "", fmt.Errorf("string %q is not unicode normalized", s)
+	}
+	return n, nil
+}
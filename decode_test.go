@@ -0,0 +1,147 @@
+package jsonparse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDecodeDuplicateKeys(t *testing.T) {
+	const body = `{"role": "user", "nested": {"a": 1, "a": 2}, "role": "admin"}`
+
+	tests := []struct {
+		policy   string
+		key      string
+		expected interface{}
+		err      bool
+	}{
+		{policy: "", key: "role", expected: "admin"},
+		{policy: duplicateKeepLast, key: "nested.a", expected: float64(2)},
+		{policy: duplicateKeepFirst, key: "role", expected: "user"},
+		{policy: duplicateKeepFirst, key: "nested.a", expected: float64(1)},
+		{policy: duplicateReject, err: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			v, err := decode([]byte(body), parseOptions{duplicateKeys: tt.policy})
+			if tt.err {
+				if err == nil {
+					t.Error("want error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val := fetchValue(v, tt.key); val != tt.expected {
+				t.Errorf("want: %v, got: %v", tt.expected, val)
+			}
+		})
+	}
+}
+
+func TestDecodeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opts := parseOptions{maxDepth: 8}
+	if _, err := decodeContext(ctx, []byte(`{"a": 1}`), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("want: %v, got: %v", context.Canceled, err)
+	}
+	if _, err := decodeContext(context.Background(), []byte(`{"a": 1}`), opts); err != nil {
+		t.Errorf("want: %v, got: %v", nil, err)
+	}
+}
+
+func TestDecodeTrailingData(t *testing.T) {
+	opts := parseOptions{duplicateKeys: duplicateReject}
+	if _, err := decode([]byte(`{"a": 1} {"b": 2}`), opts); err == nil {
+		t.Error("want error, got nil")
+	}
+	if _, err := decode([]byte(`[1, 2`), opts); err == nil {
+		t.Error("want error, got nil")
+	}
+}
+
+func TestDecodeExactNumbers(t *testing.T) {
+	const body = `{"id": 12345678901234567890, "price": 1.50, "n": [1e6]}`
+
+	for _, policy := range []string{"", duplicateReject} {
+		v, err := decode([]byte(body), parseOptions{exactNumbers: true, duplicateKeys: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"id":12345678901234567890,"n":[1e6],"price":1.50}`
+		if string(b) != expected {
+			t.Errorf("want: %v, got: %v", expected, string(b))
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	tests := []struct {
+		body  string
+		opts  parseOptions
+		valid bool
+	}{
+		{body: `{"a": [1, 2]}`, opts: parseOptions{maxDepth: 2}, valid: true},
+		{body: `{"a": [[1]]}`, opts: parseOptions{maxDepth: 2}, valid: false},
+		{body: `[[[[[[[[1]]]]]]]]`, opts: parseOptions{maxDepth: 4}, valid: false},
+		{body: `{"a": 1, "b": 2}`, opts: parseOptions{maxTokens: 6}, valid: true},
+		{body: `{"a": 1, "b": 2, "c": 3}`, opts: parseOptions{maxTokens: 6}, valid: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			if _, err := decode([]byte(tt.body), tt.opts); (err == nil) != tt.valid {
+				t.Errorf("want valid: %v, got: %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestDecodeNormalizedKeys(t *testing.T) {
+	const body = `{"caf\u00e9": "nfc", "cafe\u0301": "nfd", "ad\u200dmin": true}`
+
+	tests := []struct {
+		opts     parseOptions
+		key      string
+		expected interface{}
+		err      bool
+	}{
+		{opts: parseOptions{normalizeUnicode: true}, key: "caf\u00e9", expected: "nfd"},
+		{opts: parseOptions{normalizeUnicode: true, duplicateKeys: duplicateKeepFirst}, key: "caf\u00e9", expected: "nfc"},
+		{opts: parseOptions{normalizeUnicode: true, duplicateKeys: duplicateReject}, err: true},
+		{opts: parseOptions{normalizeUnicode: true, stripInvisible: true}, key: "admin", expected: true},
+		{opts: parseOptions{}, key: "cafe\u0301", expected: "nfd"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			// collisions must resolve the same way every time
+			for n := 0; n < 10; n++ {
+				v, err := decode([]byte(body), tt.opts)
+				if tt.err {
+					if err == nil {
+						t.Error("want error, got nil")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if val := fetchValue(v, tt.key); val != tt.expected {
+					t.Fatalf("want: %v, got: %v", tt.expected, val)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package jsonparse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseEmbedded replaces strings containing json at path in v
+// with the parsed value, so that placeholders and validations can
+// reach inside. Strings that are not valid json are left as is,
+// unless opts.strictEmbedded is set, in which case the first such
+// string is returned as an error.
+func parseEmbedded(ctx context.Context, v interface{}, path string, opts parseOptions) error {
+	strict := opts.strictEmbedded
+	opts.embedded = nil
+	var firstErr error
+	updatePath(v, strings.Split(path, "."), func(val interface{}) interface{} {
+		s, ok := val.(string)
+		if !ok {
+			return val
+		}
+		parsed, err := decodeTree(ctx, []byte(s), opts)
+		if err != nil {
+			if strict && firstErr == nil {
+				firstErr = fmt.Errorf("embedded json at %s: %w", path, err)
+			}
+			return val
+		}
+		return parsed
+	})
+	return firstErr
+}
+
+// updatePath replaces the values at keys in v with the result of f.
+// A "*" key matches every element of an array or object.
+func updatePath(v interface{}, keys []string, f func(interface{}) interface{}) {
+	k, rest := keys[0], keys[1:]
+
+	apply := func(val interface{}) interface{} {
+		if len(rest) == 0 {
+			return f(val)
+		}
+		updatePath(val, rest, f)
+		return val
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if k == "*" {
+			for key, val := range v {
+				v[key] = apply(val)
+			}
+		} else if val, ok := v[k]; ok {
+			v[k] = apply(val)
+		}
+	case []interface{}:
+		if k == "*" {
+			for i, val := range v {
+				v[i] = apply(val)
+			}
+		} else if i, err := strconv.Atoi(k); err == nil && i >= 0 && i < len(v) {
+			v[i] = apply(v[i])
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package jsonparse
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseEmbedded(t *testing.T) {
+	const body = `{"payload": "{\"action\": \"opened\"}", "events": [{"data": "[1, 2]"}, {"data": "not json"}]}`
+
+	tests := []struct {
+		key      string
+		expected interface{}
+	}{
+		{key: "payload.action", expected: "opened"},
+		{key: "events.0.data.1", expected: float64(2)},
+		{key: "events.1.data", expected: "not json"},
+	}
+
+	opts := parseOptions{embedded: []string{"payload", "events.*.data", "missing.path"}}
+	v, err := decode([]byte(body), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			if val := fetchValue(v, tt.key); val != tt.expected {
+				t.Errorf("want: %v, got: %v", tt.expected, val)
+			}
+		})
+	}
+}
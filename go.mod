@@ -4,5 +4,7 @@ go 1.14
 
 require (
 	github.com/caddyserver/caddy/v2 v2.4.1
+	github.com/dustin/go-humanize v1.0.1-0.20200219035652-afde56e7acac
 	go.uber.org/zap v1.16.0
+	golang.org/x/text v0.3.3
 )
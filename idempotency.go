@@ -0,0 +1,97 @@
+package jsonparse
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// defaultIdempotencyTTL is how long idempotency keys are
+// remembered when no ttl is configured.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeys bounds the number of remembered keys.
+// The oldest key is forgotten first.
+const maxIdempotencyKeys = 100000
+
+// keyStore remembers keys for a limited time.
+type keyStore struct {
+	ttl time.Duration
+	max int
+
+	mu   sync.Mutex
+	keys map[string]*list.Element
+
+	// order holds the keys oldest first. All keys share the
+	// same ttl, so this is also the order of expiry.
+	order *list.List
+}
+
+type storedKey struct {
+	key    string
+	expiry time.Time
+}
+
+func newKeyStore(ttl time.Duration, max int) *keyStore {
+	return &keyStore{
+		ttl:   ttl,
+		max:   max,
+		keys:  map[string]*list.Element{},
+		order: list.New(),
+	}
+}
+
+// seen records key and reports whether it was already
+// recorded and has not expired.
+func (s *keyStore) seen(key string) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for e := s.order.Front(); e != nil && now.After(e.Value.(storedKey).expiry); e = s.order.Front() {
+		s.remove(e)
+	}
+
+	if _, ok := s.keys[key]; ok {
+		return true
+	}
+	if s.max > 0 && s.order.Len() >= s.max {
+		s.remove(s.order.Front())
+	}
+	s.keys[key] = s.order.PushBack(storedKey{key: key, expiry: now.Add(s.ttl)})
+	return false
+}
+
+// release forgets key, e.g. when the request it identifies
+// failed and may be retried.
+func (s *keyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.keys[key]; ok {
+		s.remove(e)
+	}
+}
+
+func (s *keyStore) remove(e *list.Element) {
+	delete(s.keys, e.Value.(storedKey).key)
+	s.order.Remove(e)
+}
+
+// serveOnce calls next for the request identified by key, and
+// forgets key unless the request succeeded, so that deliveries
+// failed by the upstream can be retried.
+func (j JSONParse) serveOnce(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, key string) error {
+	rec := caddyhttp.NewResponseRecorder(w, nil, nil)
+	err := j.serveNext(rec, r, next)
+
+	// nothing written is an empty 200 response
+	if status := rec.Status(); err != nil || (status != 0 && (status < 200 || status > 299)) {
+		j.idempotent.release(key)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package jsonparse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestKeyStore(t *testing.T) {
+	s := newKeyStore(time.Hour, 0)
+
+	if s.seen("a") {
+		t.Error("want a unseen on first request")
+	}
+	if !s.seen("a") {
+		t.Error("want a seen on second request")
+	}
+	if s.seen("b") {
+		t.Error("want b unseen on first request")
+	}
+
+	// expire a
+	s.keys["a"].Value = storedKey{key: "a", expiry: time.Now().Add(-time.Second)}
+	if s.seen("a") {
+		t.Error("want a unseen after expiry")
+	}
+
+	s.release("b")
+	if s.seen("b") {
+		t.Error("want b unseen after release")
+	}
+}
+
+func TestKeyStoreMax(t *testing.T) {
+	s := newKeyStore(time.Hour, 2)
+
+	s.seen("a")
+	s.seen("b")
+	s.seen("c")
+
+	if len(s.keys) != 2 {
+		t.Errorf("want: %v, got: %v", 2, len(s.keys))
+	}
+	if s.seen("a") {
+		t.Error("want oldest key forgotten")
+	}
+	if !s.seen("c") {
+		t.Error("want c seen on second request")
+	}
+}
+
+func TestServeIdempotency(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	j := JSONParse{IdempotencyKey: "delivery.id"}
+	if err := j.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func(body string, upstream int) int {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer()))
+		w := httptest.NewRecorder()
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(upstream)
+			return nil
+		})
+		if err := j.ServeHTTP(w, r, next); err != nil {
+			return status(err)
+		}
+		return w.Code
+	}
+
+	tests := []struct {
+		body     string
+		upstream int
+		expected int
+	}{
+		{body: `{"delivery":{"id":"d1"}}`, upstream: http.StatusBadGateway, expected: http.StatusBadGateway},
+		{body: `{"delivery":{"id":"d1"}}`, upstream: http.StatusOK, expected: http.StatusOK},
+		{body: `{"delivery":{"id":"d1"}}`, upstream: http.StatusOK, expected: http.StatusConflict},
+		{body: `{"delivery":{"id":""}}`, upstream: http.StatusInternalServerError, expected: http.StatusInternalServerError},
+		{body: `{"delivery":{"id":""}}`, upstream: http.StatusOK, expected: http.StatusOK},
+		{body: `{"delivery":{"id":""}}`, upstream: http.StatusOK, expected: http.StatusConflict},
+	}
+
+	for i, tt := range tests {
+		if got := deliver(tt.body, tt.upstream); got != tt.expected {
+			t.Errorf("%d: want: %v, got: %v", i, tt.expected, got)
+		}
+	}
+}
@@ -1,18 +1,28 @@
 package jsonparse
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
 	"go.uber.org/zap"
 )
 
 // Interface guards
 var (
 	_ caddy.Provisioner           = (*JSONParse)(nil)
+	_ caddy.Validator             = (*JSONParse)(nil)
 	_ caddyhttp.MiddlewareHandler = (*JSONParse)(nil)
 	_ caddyfile.Unmarshaler       = (*JSONParse)(nil)
 )
@@ -25,8 +35,190 @@ func init() {
 // JSONParse implements an HTTP handler that parses
 // json body as placeholders.
 type JSONParse struct {
+	// Source is where the json is read from: "body" (default),
+	// "header:<name>" or "cookie:<name>".
+	// Values of headers and cookies are available as
+	// {json_header.<name>.*} and {json_cookie.<name>.*}.
+	Source string `json:"source,omitempty"`
+
+	// Strict rejects requests with malformed, empty, unreadable
+	// or oversized bodies. It implies StrictParse, StrictEmpty,
+	// StrictRead and StrictSize.
 	Strict bool `json:"strict,omitempty"`
-	log    *zap.Logger
+
+	// StrictParse rejects malformed json with 400.
+	StrictParse bool `json:"strict_parse,omitempty"`
+
+	// StrictEmpty rejects empty bodies with 400.
+	StrictEmpty bool `json:"strict_empty,omitempty"`
+
+	// StrictRead rejects bodies that fail to be read with 400.
+	StrictRead bool `json:"strict_read,omitempty"`
+
+	// StrictSize rejects bodies larger than MaxSize with 413
+	// instead of forwarding them unparsed. Clients sending
+	// Expect: 100-continue are rejected before uploading.
+	StrictSize bool `json:"strict_size,omitempty"`
+
+	// StrictContentType rejects requests without a json
+	// Content-Type with 415.
+	StrictContentType bool `json:"strict_content_type,omitempty"`
+
+	// Failures customizes the rejection of each failure kind:
+	// "parse", "empty", "read", "content_type", "oversize" and
+	// "duplicate" (repeated idempotency keys).
+	Failures map[string]*Failure `json:"failures,omitempty"`
+
+	// OnParseError are routes handling strict failures instead
+	// of returning the error, e.g. to respond with a branded
+	// error page. The error is available as {http.error}.
+	OnParseError caddyhttp.RouteList `json:"on_parse_error,omitempty"`
+
+	// MaxSize is the maximum body size in bytes to parse.
+	// Larger bodies are streamed through without parsing.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// MaxDepth limits the nesting of objects and arrays.
+	// Deeper bodies fail to parse.
+	MaxDepth int `json:"max_depth,omitempty"`
+
+	// MaxTokens limits the number of json tokens, i.e. keys,
+	// values and delimiters. Larger bodies fail to parse.
+	MaxTokens int `json:"max_tokens,omitempty"`
+
+	// MaxProcessingTime limits the time spent decoding a body,
+	// and separately the time spent running validations. Bodies
+	// taking longer fail to parse.
+	MaxProcessingTime caddy.Duration `json:"max_processing_time,omitempty"`
+
+	// Mirror is the URL of a shadow upstream that asynchronously
+	// receives a copy of each parsed request body.
+	Mirror string `json:"mirror,omitempty"`
+
+	// IdempotencyKey is the path to a value identifying the request.
+	// Requests repeating a recently seen value are rejected with 409.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// IdempotencyTTL is how long idempotency keys are remembered.
+	// Default is 24h.
+	IdempotencyTTL caddy.Duration `json:"idempotency_ttl,omitempty"`
+
+	// Keys maps names to paths hashed into {json_parse.key.<name>}
+	// placeholders, e.g. for keying rate limits.
+	Keys map[string][]string `json:"keys,omitempty"`
+
+	// DuplicateKeys is the policy for repeated keys in a json object:
+	// "keep_last" (default), "keep_first" or "reject". keep_first
+	// is not allowed with validations or restrictions.
+	DuplicateKeys string `json:"duplicate_keys,omitempty"`
+
+	// NormalizeUnicode NFC-normalizes keys and string values. The
+	// forwarded body is unchanged, so with validations or
+	// restrictions bodies that normalization would change are
+	// rejected instead.
+	NormalizeUnicode bool `json:"normalize_unicode,omitempty"`
+
+	// StripInvisible removes zero-width, format and control
+	// characters (except whitespace) from keys and string values.
+	StripInvisible bool `json:"strip_invisible,omitempty"`
+
+	// ExactNumbers keeps numbers in their literal form, e.g. ids
+	// above 2^53 keep their precision and 1.50 is not rendered
+	// as 1.5. Numeric placeholders are then strings in expressions.
+	ExactNumbers bool `json:"exact_numbers,omitempty"`
+
+	// ParseEmbedded lists paths to strings containing json, e.g.
+	// webhook payloads, that are parsed for placeholders and
+	// validations. The body itself is forwarded unchanged.
+	ParseEmbedded []string `json:"parse_embedded,omitempty"`
+
+	// Validations reject requests whose body fails any of them.
+	Validations []*Validation `json:"validations,omitempty"`
+
+	// NullPolicy is whether json null counts as a "missing" value
+	// (default) or a "present" one in validations.
+	NullPolicy string `json:"null_policy,omitempty"`
+
+	// Role is a placeholder holding the roles of the caller,
+	// separated by commas or spaces, e.g. {http.auth.user.role}.
+	Role string `json:"role,omitempty"`
+
+	// Restrictions reject with 403 bodies setting paths
+	// the caller's roles are not allowed to.
+	Restrictions []*Restriction `json:"restrictions,omitempty"`
+
+	// ConsumeBody forwards the request without a body once it is
+	// parsed, for upstreams that only need values from placeholders.
+	// Bodies that fail to parse are forwarded as is.
+	ConsumeBody bool `json:"consume_body,omitempty"`
+
+	// ContentType overrides the Content-Type of bodies forwarded
+	// after parsing successfully, e.g. application/json for
+	// clients sending json as text/plain.
+	ContentType string `json:"content_type,omitempty"`
+
+	// ParseResponse parses json responses of the next handlers into
+	// {json_resp.*} placeholders, e.g. for headers and logs.
+	ParseResponse bool `json:"parse_response,omitempty"`
+
+	// ExportVars publishes the parsed body as the json_parse
+	// http variable and each value as json_parse.<path>.
+	ExportVars bool `json:"export_vars,omitempty"`
+
+	log        *zap.Logger
+	source     source
+	mirror     *mirror
+	idempotent *keyStore
+
+	// onParseError is the Caddyfile segment of OnParseError,
+	// parsed as directives by parseCaddyfile.
+	onParseError *caddyfile.Dispenser
+}
+
+// Failure configures the rejection of a strict failure.
+type Failure struct {
+	// StatusCode is the response status. Defaults to 400, or 415
+	// for content_type, 413 for oversize and 409 for duplicate
+	// failures.
+	StatusCode int `json:"status_code,omitempty"`
+
+	// Message is the error message, available as
+	// {http.error.message}. Placeholders are replaced.
+	Message string `json:"message,omitempty"`
+}
+
+// Null policies.
+const (
+	nullMissing = "missing"
+	nullPresent = "present"
+)
+
+// Strict failure kinds.
+const (
+	failureParse       = "parse"
+	failureEmpty       = "empty"
+	failureRead        = "read"
+	failureContentType = "content_type"
+	failureOversize    = "oversize"
+	failureDuplicate   = "duplicate"
+)
+
+var defaultFailureStatus = map[string]int{
+	failureParse:       http.StatusBadRequest,
+	failureEmpty:       http.StatusBadRequest,
+	failureRead:        http.StatusBadRequest,
+	failureContentType: http.StatusUnsupportedMediaType,
+	failureOversize:    http.StatusRequestEntityTooLarge,
+	failureDuplicate:   http.StatusConflict,
+}
+
+// checkStatus returns an error if code is set but is not a valid
+// status code, which would make net/http panic when written.
+func checkStatus(code int) error {
+	if code != 0 && (code < 100 || code > 999) {
+		return fmt.Errorf("invalid status code %d", code)
+	}
+	return nil
 }
 
 // CaddyModule returns the Caddy module information.
@@ -41,6 +233,146 @@ func (JSONParse) CaddyModule() caddy.ModuleInfo {
 func (j *JSONParse) Provision(ctx caddy.Context) error {
 	j.log = ctx.Logger(j)
 
+	src, err := parseSource(j.Source)
+	if err != nil {
+		return err
+	}
+	j.source = src
+
+	if j.StrictSize && j.MaxSize <= 0 {
+		return fmt.Errorf("strict_size requires max_size")
+	}
+
+	if j.Mirror != "" {
+		if !j.source.isBody() {
+			return fmt.Errorf("mirror requires the body source")
+		}
+		m, err := newMirror(j.Mirror, j.log)
+		if err != nil {
+			return err
+		}
+		j.mirror = m
+	}
+
+	if j.ConsumeBody && !j.source.isBody() {
+		return fmt.Errorf("consume_body requires the body source")
+	}
+
+	if j.ContentType != "" {
+		if !j.source.isBody() {
+			return fmt.Errorf("content_type requires the body source")
+		}
+		if j.ConsumeBody {
+			return fmt.Errorf("content_type conflicts with consume_body")
+		}
+		if _, _, err := mime.ParseMediaType(j.ContentType); err != nil {
+			return fmt.Errorf("invalid content_type '%s': %v", j.ContentType, err)
+		}
+	}
+
+	switch j.DuplicateKeys {
+	case "", duplicateKeepLast, duplicateKeepFirst, duplicateReject:
+	default:
+		return fmt.Errorf("invalid duplicate_keys policy: %s", j.DuplicateKeys)
+	}
+	if j.DuplicateKeys == duplicateKeepFirst && j.failClosed() {
+		// most upstreams keep the last value, so checks would
+		// pass a value other than the one the upstream uses.
+		return fmt.Errorf("duplicate_keys %s cannot be used with validations or restrictions, use %s", duplicateKeepFirst, duplicateReject)
+	}
+
+	switch j.NullPolicy {
+	case "", nullMissing, nullPresent:
+	default:
+		return fmt.Errorf("invalid null_policy: %s", j.NullPolicy)
+	}
+
+	for _, v := range j.Validations {
+		if err := v.provision(); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range j.ParseEmbedded {
+		if err := checkPath(path); err != nil {
+			return fmt.Errorf("parse_embedded: %v", err)
+		}
+	}
+
+	if len(j.Restrictions) > 0 && j.Role == "" {
+		return fmt.Errorf("restrictions require a role placeholder")
+	}
+	for _, res := range j.Restrictions {
+		if err := checkPath(res.Path); err != nil {
+			return fmt.Errorf("restriction: %v", err)
+		}
+	}
+
+	if err := j.OnParseError.Provision(ctx); err != nil {
+		return fmt.Errorf("on_parse_error: %v", err)
+	}
+
+	for kind, f := range j.Failures {
+		if _, ok := defaultFailureStatus[kind]; !ok {
+			return fmt.Errorf("invalid failure kind: %s", kind)
+		}
+		if err := checkStatus(f.StatusCode); err != nil {
+			return fmt.Errorf("failure %s: %v", kind, err)
+		}
+	}
+
+	for name, paths := range j.Keys {
+		for _, path := range paths {
+			if err := checkPath(path); err != nil {
+				return fmt.Errorf("key %s: %v", name, err)
+			}
+		}
+	}
+
+	if j.IdempotencyKey != "" {
+		if err := checkPath(j.IdempotencyKey); err != nil {
+			return fmt.Errorf("idempotency_key: %v", err)
+		}
+		ttl := time.Duration(j.IdempotencyTTL)
+		if ttl <= 0 {
+			ttl = defaultIdempotencyTTL
+		}
+		j.idempotent = newKeyStore(ttl, maxIdempotencyKeys)
+	}
+
+	return nil
+}
+
+// Validate implements caddy.Validator.
+func (j *JSONParse) Validate() error {
+	// allowed values of validations on the same path
+	// must have a value in common.
+	allowed := map[string][]string{}
+
+	for _, v := range j.Validations {
+		if err := v.check(); err != nil {
+			return err
+		}
+
+		if len(v.Values) == 0 {
+			continue
+		}
+		if prev, ok := allowed[v.Path]; ok {
+			var common []string
+			for _, val := range v.Values {
+				if contains(prev, val) {
+					common = append(common, val)
+				}
+			}
+			if len(common) == 0 {
+				return fmt.Errorf("validations %s: allowed values have nothing in common", v.Path)
+			}
+			allowed[v.Path] = common
+		} else {
+			allowed[v.Path] = v.Values
+		}
+	}
+
 	return nil
 }
 
@@ -48,19 +380,180 @@ func (j *JSONParse) Provision(ctx caddy.Context) error {
 func (j JSONParse) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
 
-	replacerFunc, err := newReplacerFunc(r)
-	if err != nil {
-		if j.Strict {
-			return caddyhttp.Error(http.StatusBadRequest, err)
+	// a client expecting 100-continue waits for approval before
+	// uploading. Reject oversized bodies before the body is read,
+	// which is what sends the interim response.
+	if j.source.isBody() {
+		if j.rejectsOversize() && j.MaxSize > 0 && r.ContentLength > j.MaxSize && expectsContinue(r) {
+			return j.reject(w, r, failureOversize, errBodyTooLarge)
+		}
+
+		if j.StrictContentType && !isJSONContentType(r.Header) {
+			return j.reject(w, r, failureContentType, fmt.Errorf("unsupported content type: %s", r.Header.Get("Content-Type")))
+		}
+	}
+
+	doc, r, fresh := parseDocument(r, j.parseOptions())
+	if doc.err == errBodyTooLarge {
+		if j.rejectsOversize() {
+			return j.reject(w, r, failureOversize, doc.err)
+		}
+		j.log.Debug("skipping body", zap.Int64("max_size", j.MaxSize))
+		return j.serveNext(w, r, next)
+	}
+	if doc.err != nil {
+		kind, reject := j.strictFailure(doc.err)
+		if reject || (j.failClosed() && doc.err != errEmptyBody) {
+			return j.reject(w, r, kind, doc.err)
+		}
+		j.log.Debug("", zap.Error(doc.err))
+	}
+
+	// placeholders are already available if an earlier
+	// handler parsed the body.
+	if doc.raw != nil && fresh && j.source.isBody() {
+		repl.Map(newRawReplacerFunc(doc.raw))
+	}
+	if doc.err == nil && fresh {
+		repl.Map(newReplacerFunc(j.source.placeholderPrefix(), doc.value))
+	}
+	if doc.err == nil && j.ExportVars {
+		setVars(r.Context(), doc.value)
+	}
+	if doc.err == nil && len(j.Keys) > 0 {
+		repl.Map(newKeysReplacerFunc(doc.value, j.Keys))
+	}
+
+	if (doc.err == nil || doc.err == errEmptyBody) && len(j.Validations) > 0 {
+		ctx := r.Context()
+		if j.MaxProcessingTime > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(j.MaxProcessingTime))
+			defer cancel()
+		}
+		for _, v := range j.Validations {
+			if err := v.validate(ctx, repl, doc.value, j.NullPolicy == nullPresent); err != nil {
+				return v.fail(repl, err)
+			}
+		}
+	}
+
+	if doc.err == nil && len(j.Restrictions) > 0 {
+		roles := splitRoles(repl.ReplaceAll(j.Role, ""))
+		for _, res := range j.Restrictions {
+			if err := res.authorize(doc.value, roles); err != nil {
+				return caddyhttp.Error(http.StatusForbidden, err)
+			}
 		}
-		j.log.Debug("", zap.Error(err))
 	}
 
-	if err == nil {
-		repl.Map(replacerFunc)
+	// the key may be empty, so whether one was recorded is
+	// tracked separately.
+	var idempotencyKey string
+	var idempotent bool
+	if j.idempotent != nil && doc.err == nil {
+		if key := fetchValue(doc.value, j.IdempotencyKey); key != nil {
+			idempotencyKey, idempotent = fmt.Sprint(key), true
+			if j.idempotent.seen(idempotencyKey) {
+				return j.fail(r, failureDuplicate, fmt.Errorf("duplicate idempotency key: %v", key))
+			}
+		}
+	}
+
+	if j.mirror != nil && doc.err == nil {
+		j.mirror.send(r, doc.raw)
+	}
+
+	if j.ConsumeBody && doc.err == nil {
+		dropBody(r)
+	}
+	if j.ContentType != "" && doc.err == nil {
+		r.Header.Set("Content-Type", j.ContentType)
+	}
+
+	if idempotent {
+		return j.serveOnce(w, r, next, idempotencyKey)
+	}
+	return j.serveNext(w, r, next)
+}
+
+// strictFailure returns the failure kind of err and whether
+// the request is rejected for it.
+func (j JSONParse) strictFailure(err error) (kind string, reject bool) {
+	var re readError
+	var de duplicateKeyError
+	switch {
+	case errors.As(err, &de):
+		// rejecting duplicate keys is pointless if the
+		// body is forwarded regardless.
+		return failureParse, true
+	case errors.As(err, &re):
+		return failureRead, j.Strict || j.StrictRead
+	case err == errEmptyBody:
+		return failureEmpty, j.Strict || j.StrictEmpty
 	}
+	return failureParse, j.Strict || j.StrictParse
+}
+
+// failClosed reports whether bodies that cannot be checked, i.e.
+// that are too large or fail to parse, are rejected rather than
+// forwarded unchecked. Empty bodies are checked as having no values.
+func (j JSONParse) failClosed() bool {
+	return len(j.Validations) > 0 || len(j.Restrictions) > 0
+}
 
-	return next.ServeHTTP(w, r)
+// rejectsOversize reports whether bodies larger than MaxSize
+// are rejected rather than forwarded unparsed.
+func (j JSONParse) rejectsOversize() bool {
+	return j.Strict || j.StrictSize || j.failClosed()
+}
+
+// fail returns the error rejecting a request for a failure of kind,
+// using the configured status code and message if any.
+func (j JSONParse) fail(r *http.Request, kind string, err error) error {
+	status := defaultFailureStatus[kind]
+	if f, ok := j.Failures[kind]; ok {
+		if f.StatusCode != 0 {
+			status = f.StatusCode
+		}
+		if f.Message != "" {
+			repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+			err = errors.New(repl.ReplaceAll(f.Message, ""))
+		}
+	}
+	return caddyhttp.Error(status, err)
+}
+
+// reject handles a strict failure of kind with the OnParseError
+// routes if any, or returns the error rejecting the request.
+// The error is returned as well if the routes do not respond.
+func (j JSONParse) reject(w http.ResponseWriter, r *http.Request, kind string, err error) error {
+	err = j.fail(r, kind, err)
+	if len(j.OnParseError) == 0 {
+		return err
+	}
+	r = new(caddyhttp.HTTPErrorConfig).WithError(r, err)
+	return j.OnParseError.Compile(caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+		return err
+	})).ServeHTTP(w, r)
+}
+
+// parseOptions returns the options for parsing request bodies.
+func (j JSONParse) parseOptions() parseOptions {
+	return parseOptions{
+		source:           j.source,
+		maxSize:          j.MaxSize,
+		duplicateKeys:    j.DuplicateKeys,
+		normalizeUnicode: j.NormalizeUnicode,
+		stripInvisible:   j.StripInvisible,
+		strictNormalized: j.failClosed(),
+		exactNumbers:     j.ExactNumbers,
+		embedded:         j.ParseEmbedded,
+		strictEmbedded:   j.failClosed(),
+		maxDepth:         j.MaxDepth,
+		maxTokens:        j.MaxTokens,
+		maxTime:          time.Duration(j.MaxProcessingTime),
+	}
 }
 
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler.
@@ -77,6 +570,205 @@ func (j *JSONParse) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		default:
 			return d.ArgErr()
 		}
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "source":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				j.Source = d.Val()
+			case "strict_parse":
+				j.StrictParse = true
+			case "strict_empty":
+				j.StrictEmpty = true
+			case "strict_read":
+				j.StrictRead = true
+			case "strict_size":
+				j.StrictSize = true
+			case "strict_content_type":
+				j.StrictContentType = true
+			case "failure":
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return d.ArgErr()
+				}
+				status, err := strconv.Atoi(args[1])
+				if err != nil {
+					return d.Errf("invalid status code '%s': %v", args[1], err)
+				}
+				f := &Failure{StatusCode: status}
+				if len(args) == 3 {
+					f.Message = args[2]
+				}
+				if j.Failures == nil {
+					j.Failures = map[string]*Failure{}
+				}
+				j.Failures[args[0]] = f
+			case "on_parse_error":
+				j.onParseError = d.NewFromNextSegment()
+			case "max_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_size '%s': %v", d.Val(), err)
+				}
+				j.MaxSize = int64(size)
+			case "max_depth", "max_tokens":
+				name := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid %s '%s': %v", name, d.Val(), err)
+				}
+				if name == "max_depth" {
+					j.MaxDepth = n
+				} else {
+					j.MaxTokens = n
+				}
+			case "max_processing_time":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid max_processing_time '%s': %v", d.Val(), err)
+				}
+				j.MaxProcessingTime = caddy.Duration(dur)
+			case "mirror":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				j.Mirror = d.Val()
+			case "idempotency_key":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				j.IdempotencyKey = args[0]
+				if len(args) == 2 {
+					ttl, err := caddy.ParseDuration(args[1])
+					if err != nil {
+						return d.Errf("invalid idempotency_key ttl '%s': %v", args[1], err)
+					}
+					j.IdempotencyTTL = caddy.Duration(ttl)
+				}
+			case "key":
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.ArgErr()
+				}
+				if j.Keys == nil {
+					j.Keys = map[string][]string{}
+				}
+				j.Keys[args[0]] = args[1:]
+			case "duplicate_keys":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				j.DuplicateKeys = d.Val()
+			case "normalize_unicode":
+				j.NormalizeUnicode = true
+				if d.NextArg() {
+					if d.Val() != "strip" {
+						return d.Errf("unexpected token '%s'", d.Val())
+					}
+					j.StripInvisible = true
+				}
+			case "exact_numbers":
+				j.ExactNumbers = true
+			case "parse_embedded":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				j.ParseEmbedded = append(j.ParseEmbedded, args...)
+			case "validate":
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 4 {
+					return d.ArgErr()
+				}
+				v := &Validation{Path: args[0], Regex: args[1]}
+				if len(args) > 2 {
+					status, err := strconv.Atoi(args[2])
+					if err != nil {
+						return d.Errf("invalid status code '%s': %v", args[2], err)
+					}
+					v.StatusCode = status
+				}
+				if len(args) > 3 {
+					v.Message = args[3]
+				}
+				j.Validations = append(j.Validations, v)
+			case "allow_values":
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.ArgErr()
+				}
+				j.Validations = append(j.Validations, &Validation{Path: args[0], Values: args[1:]})
+			case "constrain":
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.ArgErr()
+				}
+				v := &Validation{Path: args[0]}
+				if err := v.parseConstraints(args[1:]); err != nil {
+					return d.Err(err.Error())
+				}
+				j.Validations = append(j.Validations, v)
+			case "null_policy":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				j.NullPolicy = d.Val()
+			case "role":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				j.Role = d.Val()
+			case "restrict":
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.ArgErr()
+				}
+				j.Restrictions = append(j.Restrictions, &Restriction{Path: args[0], Roles: args[1:]})
+			case "consume_body":
+				j.ConsumeBody = true
+			case "content_type":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				j.ContentType = d.Val()
+			case "parse_response":
+				j.ParseResponse = true
+			case "export_vars":
+				j.ExportVars = true
+			case "guard_urls":
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.ArgErr()
+				}
+				v := &Validation{Path: args[0]}
+				if err := v.parseURLGuard(args[1:]); err != nil {
+					return d.Err(err.Error())
+				}
+				j.Validations = append(j.Validations, v)
+			case "require_fields":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				for _, path := range args {
+					j.Validations = append(j.Validations, &Validation{Path: path, Required: true})
+				}
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
 	}
 	return nil
 }
@@ -84,6 +776,19 @@ func (j *JSONParse) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 // parseCaddyfile unmarshals tokens from h into a new Middleware.
 func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var m JSONParse
-	err := m.UnmarshalCaddyfile(h.Dispenser)
-	return m, err
+	if err := m.UnmarshalCaddyfile(h.Dispenser); err != nil {
+		return nil, err
+	}
+
+	if m.onParseError != nil {
+		handler, err := httpcaddyfile.ParseSegmentAsSubroute(h.WithDispenser(m.onParseError))
+		if err != nil {
+			return nil, err
+		}
+		m.OnParseError = caddyhttp.RouteList{{
+			HandlersRaw: []json.RawMessage{caddyconfig.JSONModuleObject(handler, "handler", "subroute", nil)},
+		}}
+	}
+
+	return m, nil
 }
@@ -0,0 +1,658 @@
+package jsonparse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestStrictFailure(t *testing.T) {
+	parseErr := errors.New("invalid character")
+	readErr := readError{errors.New("connection reset")}
+
+	tests := []struct {
+		handler JSONParse
+		err     error
+		kind    string
+		reject  bool
+	}{
+		{handler: JSONParse{}, err: parseErr, kind: failureParse, reject: false},
+		{handler: JSONParse{Strict: true}, err: parseErr, kind: failureParse, reject: true},
+		{handler: JSONParse{Strict: true}, err: errEmptyBody, kind: failureEmpty, reject: true},
+		{handler: JSONParse{Strict: true}, err: readErr, kind: failureRead, reject: true},
+		{handler: JSONParse{StrictParse: true}, err: parseErr, kind: failureParse, reject: true},
+		{handler: JSONParse{StrictParse: true}, err: errEmptyBody, kind: failureEmpty, reject: false},
+		{handler: JSONParse{StrictEmpty: true}, err: errEmptyBody, kind: failureEmpty, reject: true},
+		{handler: JSONParse{StrictRead: true}, err: readErr, kind: failureRead, reject: true},
+		{handler: JSONParse{StrictRead: true}, err: parseErr, kind: failureParse, reject: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			kind, reject := tt.handler.strictFailure(tt.err)
+			if kind != tt.kind {
+				t.Errorf("want: %v, got: %v", tt.kind, kind)
+			}
+			if reject != tt.reject {
+				t.Errorf("want: %v, got: %v", tt.reject, reject)
+			}
+		})
+	}
+}
+
+func TestFail(t *testing.T) {
+	j := JSONParse{
+		Failures: map[string]*Failure{
+			failureParse: {StatusCode: http.StatusUnprocessableEntity, Message: "bad json for {http.request.uri.path}"},
+		},
+	}
+
+	r := httptest.NewRequest("POST", "/hook", nil)
+	repl := caddy.NewReplacer()
+	repl.Map(func(key string) (interface{}, bool) {
+		if key == "http.request.uri.path" {
+			return r.URL.Path, true
+		}
+		return nil, false
+	})
+	r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+
+	var he caddyhttp.HandlerError
+	if !errors.As(j.fail(r, failureParse, errors.New("invalid")), &he) {
+		t.Fatal("want caddyhttp.HandlerError")
+	}
+	if he.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("want: %v, got: %v", http.StatusUnprocessableEntity, he.StatusCode)
+	}
+	if he.Err.Error() != "bad json for /hook" {
+		t.Errorf("want: %v, got: %v", "bad json for /hook", he.Err)
+	}
+
+	// unconfigured kinds use the default status
+	if !errors.As(j.fail(r, failureContentType, errors.New("text/plain")), &he) {
+		t.Fatal("want caddyhttp.HandlerError")
+	}
+	if he.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("want: %v, got: %v", http.StatusUnsupportedMediaType, he.StatusCode)
+	}
+}
+
+func TestReject(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	j := JSONParse{
+		OnParseError: caddyhttp.RouteList{{
+			HandlersRaw: []json.RawMessage{json.RawMessage(`{"handler":"static_response","status_code":422,"body":"legacy"}`)},
+		}},
+	}
+	if err := j.OnParseError.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	repl := caddy.NewReplacer()
+	r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+	w := httptest.NewRecorder()
+
+	if err := j.reject(w, r, failureParse, errors.New("invalid")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want: %v, got: %v", http.StatusUnprocessableEntity, w.Code)
+	}
+	if w.Body.String() != "legacy" {
+		t.Errorf("want: %v, got: %v", "legacy", w.Body.String())
+	}
+	if status, _ := repl.Get("http.error.status_code"); status != http.StatusBadRequest {
+		t.Errorf("want: %v, got: %v", http.StatusBadRequest, status)
+	}
+}
+
+// serve provisions j and serves r with it, returning the request
+// passed to the next handler, if called, and the handler error.
+func serve(t *testing.T, j *JSONParse, r *http.Request) (*http.Request, error) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	if err := j.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	repl := caddy.NewReplacer()
+	r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+
+	var forwarded *http.Request
+	next := caddyhttp.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) error {
+		forwarded = r
+		return nil
+	})
+	err := j.ServeHTTP(httptest.NewRecorder(), r, next)
+	return forwarded, err
+}
+
+// status returns the status code of a handler error, or 0.
+func status(err error) int {
+	var he caddyhttp.HandlerError
+	if errors.As(err, &he) {
+		return he.StatusCode
+	}
+	return 0
+}
+
+func TestServeDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		handler JSONParse
+		body    string
+		status  int
+	}{
+		{handler: JSONParse{}, body: `{"role":"user","role":"admin"}`, status: 0},
+		{handler: JSONParse{DuplicateKeys: duplicateReject}, body: `{"role":"user","role":"admin"}`, status: http.StatusBadRequest},
+		{handler: JSONParse{DuplicateKeys: duplicateReject}, body: `{"role":"user"}`, status: 0},
+		{handler: JSONParse{DuplicateKeys: duplicateReject}, body: `{"role":`, status: 0},
+		{handler: JSONParse{DuplicateKeys: duplicateReject, StrictParse: true}, body: `{"role":`, status: http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			forwarded, err := serve(t, &tt.handler, r)
+			if got := status(err); got != tt.status {
+				t.Errorf("want: %v, got: %v", tt.status, got)
+			}
+			if called := forwarded != nil; called != (tt.status == 0) {
+				t.Errorf("want: %v, got: %v", tt.status == 0, called)
+			}
+		})
+	}
+}
+
+func TestServeOversize(t *testing.T) {
+	body := `{"pad":"` + strings.Repeat("x", 64) + `"}`
+
+	tests := []struct {
+		handler JSONParse
+		expect  bool
+		status  int
+	}{
+		{handler: JSONParse{MaxSize: 16}, status: 0},
+		{handler: JSONParse{MaxSize: 16}, expect: true, status: 0},
+		{handler: JSONParse{MaxSize: 16, StrictParse: true}, expect: true, status: 0},
+		{handler: JSONParse{MaxSize: 16, Strict: true}, expect: true, status: http.StatusRequestEntityTooLarge},
+		{handler: JSONParse{MaxSize: 16, StrictSize: true}, expect: true, status: http.StatusRequestEntityTooLarge},
+		{handler: JSONParse{MaxSize: 16, StrictSize: true}, status: http.StatusRequestEntityTooLarge},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			if tt.expect {
+				r.Header.Set("Expect", "100-continue")
+			}
+			// track whether the body was read
+			read := &countingReader{r: r.Body}
+			r.Body = ioutil.NopCloser(read)
+
+			forwarded, err := serve(t, &tt.handler, r)
+			if got := status(err); got != tt.status {
+				t.Errorf("want: %v, got: %v", tt.status, got)
+			}
+			if called := forwarded != nil; called != (tt.status == 0) {
+				t.Errorf("want: %v, got: %v", tt.status == 0, called)
+			}
+			if tt.status != 0 && tt.expect && read.n > 0 {
+				t.Errorf("want body unread before 100-continue, read %d bytes", read.n)
+			}
+		})
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestServeRestrictions(t *testing.T) {
+	restricted := func() JSONParse {
+		return JSONParse{
+			Role:         "{role}",
+			Restrictions: []*Restriction{{Path: "price_override", Roles: []string{"admin"}}},
+			MaxSize:      64,
+		}
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"id":1}`, status: 0},
+		{body: ``, status: 0},
+		{body: `{"price_override":0}`, status: http.StatusForbidden},
+		{body: `{"price_override":0,"pad":"` + strings.Repeat("x", 64) + `"}`, status: http.StatusRequestEntityTooLarge},
+		{body: `{"price_override":0} {}`, status: http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := restricted()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			forwarded, err := serve(t, &j, r)
+			if got := status(err); got != tt.status {
+				t.Errorf("want: %v, got: %v", tt.status, got)
+			}
+			if called := forwarded != nil; called != (tt.status == 0) {
+				t.Errorf("want: %v, got: %v", tt.status == 0, called)
+			}
+		})
+	}
+}
+
+func TestServeValidations(t *testing.T) {
+	validated := func() JSONParse {
+		return JSONParse{
+			Validations: []*Validation{{Path: "ref", Required: true, Regex: "^refs/"}},
+			MaxSize:     64,
+		}
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"ref":"refs/heads/master"}`, status: 0},
+		{body: `{"ref":"master"}`, status: http.StatusBadRequest},
+		{body: ``, status: http.StatusBadRequest},
+		{body: `{"id":1}`, status: http.StatusBadRequest},
+		{body: `{"ref":"master","pad":"` + strings.Repeat("x", 64) + `"}`, status: http.StatusRequestEntityTooLarge},
+		{body: `{"ref":"master"`, status: http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := validated()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			forwarded, err := serve(t, &j, r)
+			if got := status(err); got != tt.status {
+				t.Errorf("want: %v, got: %v", tt.status, got)
+			}
+			if called := forwarded != nil; called != (tt.status == 0) {
+				t.Errorf("want: %v, got: %v", tt.status == 0, called)
+			}
+		})
+	}
+}
+
+func TestServeEmbedded(t *testing.T) {
+	embedded := func() JSONParse {
+		return JSONParse{
+			DuplicateKeys: duplicateReject,
+			ParseEmbedded: []string{"payload"},
+			Validations:   []*Validation{{Path: "payload.role", Values: []string{"user"}}},
+		}
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"payload":"{\"role\":\"user\"}"}`, status: 0},
+		{body: `{"payload":"{\"role\":\"admin\"}"}`, status: http.StatusBadRequest},
+		{body: `{"payload":"{\"role\":\"user\",\"role\":\"admin\"}"}`, status: http.StatusBadRequest},
+		{body: `{"payload":"{\"role\":\"user\""}`, status: http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := embedded()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			forwarded, err := serve(t, &j, r)
+			if got := status(err); got != tt.status {
+				t.Errorf("want: %v, got: %v", tt.status, got)
+			}
+			if called := forwarded != nil; called != (tt.status == 0) {
+				t.Errorf("want: %v, got: %v", tt.status == 0, called)
+			}
+		})
+	}
+}
+
+func TestServeNormalized(t *testing.T) {
+	tests := []struct {
+		j      JSONParse
+		body   string
+		status int
+	}{
+		{j: JSONParse{NormalizeUnicode: true, StripInvisible: true}, body: `{"method":"eth_\u200bcall"}`, status: 0},
+		{j: JSONParse{NormalizeUnicode: true, StripInvisible: true, Validations: []*Validation{{Path: "method", Values: []string{"eth_call"}}}}, body: `{"method":"eth_call"}`, status: 0},
+		{j: JSONParse{NormalizeUnicode: true, StripInvisible: true, Validations: []*Validation{{Path: "method", Values: []string{"eth_call"}}}}, body: `{"method":"eth_\u200bcall"}`, status: http.StatusBadRequest},
+		{j: JSONParse{NormalizeUnicode: true, Validations: []*Validation{{Path: "method", Values: []string{"eth_call"}}}}, body: `{"method":"eth_call","caf\u0065\u0301":1}`, status: http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			forwarded, err := serve(t, &tt.j, r)
+			if got := status(err); got != tt.status {
+				t.Errorf("want: %v, got: %v", tt.status, got)
+			}
+			if called := forwarded != nil; called != (tt.status == 0) {
+				t.Errorf("want: %v, got: %v", tt.status == 0, called)
+			}
+		})
+	}
+}
+
+func TestServeProcessingTime(t *testing.T) {
+	body := "[" + strings.Repeat("1,", 100000) + "1]"
+
+	tests := []struct {
+		j      JSONParse
+		status int
+	}{
+		{j: JSONParse{MaxProcessingTime: caddy.Duration(time.Minute), StrictParse: true}, status: 0},
+		{j: JSONParse{MaxProcessingTime: 1}, status: 0},
+		{j: JSONParse{MaxProcessingTime: 1, StrictParse: true}, status: http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			forwarded, err := serve(t, &tt.j, r)
+			if got := status(err); got != tt.status {
+				t.Errorf("want: %v, got: %v", tt.status, got)
+			}
+			if called := forwarded != nil; called != (tt.status == 0) {
+				t.Errorf("want: %v, got: %v", tt.status == 0, called)
+			}
+		})
+	}
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	tests := []JSONParse{
+		{ConsumeBody: true, Source: "header:X-Info"},
+		{StrictSize: true},
+		{ContentType: "application/json", Source: "cookie:prefs"},
+		{ContentType: "application/json", ConsumeBody: true},
+		{ContentType: "application/"},
+		{Failures: map[string]*Failure{failureParse: {StatusCode: 42}}},
+		{Failures: map[string]*Failure{failureParse: {StatusCode: 1000}}},
+		{DuplicateKeys: duplicateKeepFirst, Validations: []*Validation{{Path: "role", Values: []string{"user"}}}},
+		{DuplicateKeys: duplicateKeepFirst, Restrictions: []*Restriction{{Path: "price_override", Roles: []string{"admin"}}}},
+	}
+
+	for i, j := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			if err := j.Provision(ctx); err == nil {
+				t.Error("want error, got nil")
+			}
+		})
+	}
+}
+
+func TestServeConsumeBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		consumed bool
+	}{
+		{body: `{"id":1}`, consumed: true},
+		{body: `{"id":`, consumed: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := JSONParse{ConsumeBody: true}
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			forwarded, err := serve(t, &j, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if consumed := forwarded.Body == http.NoBody; consumed != tt.consumed {
+				t.Errorf("want: %v, got: %v", tt.consumed, consumed)
+			}
+			if consumed := forwarded.Header.Get("Content-Type") == ""; consumed != tt.consumed {
+				t.Errorf("want: %v, got: %v", tt.consumed, consumed)
+			}
+		})
+	}
+}
+
+func TestServeContentType(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{body: `{"id":1}`, expected: "application/json"},
+		{body: `{"id":`, expected: "text/plain"},
+		{body: ``, expected: "text/plain"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := JSONParse{ContentType: "application/json"}
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "text/plain")
+			forwarded, err := serve(t, &j, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := forwarded.Header.Get("Content-Type"); got != tt.expected {
+				t.Errorf("want: %v, got: %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	one, ten := float64(1), float64(10)
+
+	tests := []struct {
+		validations []*Validation
+		valid       bool
+	}{
+		{validations: []*Validation{{Path: "n", Min: &one, Max: &ten}}, valid: true},
+		{validations: []*Validation{{Path: "n", Min: &ten, Max: &one}}, valid: false},
+		{validations: []*Validation{{Path: "m", Regex: "^eth_", Values: []string{"eth_call", "net_version"}}}, valid: true},
+		{validations: []*Validation{{Path: "m", Regex: "^eth_", Values: []string{"net_version"}}}, valid: false},
+		{validations: []*Validation{{Path: "m", Values: []string{"a", "b"}}, {Path: "m", Values: []string{"b"}}}, valid: true},
+		{validations: []*Validation{{Path: "m", Values: []string{"a"}}, {Path: "m", Values: []string{"b"}}}, valid: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := JSONParse{Validations: tt.validations}
+			for _, v := range j.Validations {
+				if err := v.provision(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := j.Validate(); (err == nil) != tt.valid {
+				t.Errorf("want valid: %v, got: %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		{input: `json_parse`, expected: `{}`, valid: true},
+		{input: `json_parse strict`, expected: `{"strict":true}`, valid: true},
+		{input: `json_parse lenient`},
+		{input: `json_parse strict extra`},
+		{input: `json_parse {
+			source header:X-Info
+			strict_parse
+			strict_empty
+			strict_read
+			strict_size
+			strict_content_type
+		}`, expected: `{"source":"header:X-Info","strict_parse":true,"strict_empty":true,"strict_read":true,"strict_size":true,"strict_content_type":true}`, valid: true},
+		{input: `json_parse {
+			failure parse 422 "invalid json"
+			failure oversize 413
+		}`, expected: `{"failures":{"oversize":{"status_code":413},"parse":{"status_code":422,"message":"invalid json"}}}`, valid: true},
+		{input: `json_parse {
+			failure parse
+		}`},
+		{input: `json_parse {
+			failure parse abc
+		}`},
+		{input: `json_parse {
+			max_size 1KB
+			max_depth 8
+			max_tokens 100
+			max_processing_time 50ms
+		}`, expected: `{"max_size":1000,"max_depth":8,"max_tokens":100,"max_processing_time":50000000}`, valid: true},
+		{input: `json_parse {
+			max_size lots
+		}`},
+		{input: `json_parse {
+			max_depth deep
+		}`},
+		{input: `json_parse {
+			max_processing_time soon
+		}`},
+		{input: `json_parse {
+			mirror http://shadow:8080
+			idempotency_key delivery.id 1h
+			key rpc account.id method
+		}`, expected: `{"mirror":"http://shadow:8080","idempotency_key":"delivery.id","idempotency_ttl":3600000000000,"keys":{"rpc":["account.id","method"]}}`, valid: true},
+		{input: `json_parse {
+			idempotency_key delivery.id forever
+		}`},
+		{input: `json_parse {
+			key rpc
+		}`},
+		{input: `json_parse {
+			duplicate_keys reject
+			normalize_unicode strip
+			exact_numbers
+			parse_embedded payload events.*.data
+		}`, expected: `{"duplicate_keys":"reject","normalize_unicode":true,"strip_invisible":true,"exact_numbers":true,"parse_embedded":["payload","events.*.data"]}`, valid: true},
+		{input: `json_parse {
+			normalize_unicode nfkc
+		}`},
+		{input: `json_parse {
+			validate ref ^refs/heads/ 422 "bad ref"
+			require_fields id
+			allow_values method eth_call
+			constrain page.size min=1 max=100
+			guard_urls callback deny_private
+		}`, expected: `{"validations":[{"path":"ref","regex":"^refs/heads/","status_code":422,"message":"bad ref"},{"path":"id","required":true},{"path":"method","values":["eth_call"]},{"path":"page.size","min":1,"max":100},{"path":"callback","deny_private":true}]}`, valid: true},
+		{input: `json_parse {
+			validate ref
+		}`},
+		{input: `json_parse {
+			validate ref ^refs/ abc
+		}`},
+		{input: `json_parse {
+			constrain page.size min=abc
+		}`},
+		{input: `json_parse {
+			guard_urls callback
+		}`},
+		{input: `json_parse {
+			null_policy present
+			role {http.request.header.X-Role}
+			restrict price_override admin
+		}`, expected: `{"null_policy":"present","role":"{http.request.header.X-Role}","restrictions":[{"path":"price_override","roles":["admin"]}]}`, valid: true},
+		{input: `json_parse {
+			restrict price_override
+		}`},
+		{input: `json_parse {
+			consume_body
+			content_type application/x-www-form-urlencoded
+			parse_response
+			export_vars
+		}`, expected: `{"consume_body":true,"content_type":"application/x-www-form-urlencoded","parse_response":true,"export_vars":true}`, valid: true},
+		{input: `json_parse {
+			unknown
+		}`},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var j JSONParse
+			err := j.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input))
+			if (err == nil) != tt.valid {
+				t.Fatalf("want valid: %v, got: %v", tt.valid, err)
+			}
+			if !tt.valid {
+				return
+			}
+			b, err := json.Marshal(j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.expected {
+				t.Errorf("want: %v, got: %v", tt.expected, string(b))
+			}
+		})
+	}
+}
+
+func TestUnmarshalCaddyfileOnParseError(t *testing.T) {
+	input := `:8080
+	route {
+		json_parse strict {
+			on_parse_error {
+				respond "bad json" 422
+			}
+		}
+	}`
+
+	adapter := caddyconfig.GetAdapter("caddyfile")
+	b, _, err := adapter.Adapt([]byte(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `"on_parse_error":[{"handle":[{"handler":"subroute","routes":[{"handle":[{"body":"bad json","handler":"static_response","status_code":422}]}]}]}]`
+	if !strings.Contains(string(b), expected) {
+		t.Errorf("want: %v, got: %v", expected, string(b))
+	}
+
+	// the segment is kept for parseCaddyfile to build the routes
+	var j JSONParse
+	err = j.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`json_parse {
+		on_parse_error {
+			respond 422
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.onParseError == nil {
+		t.Error("want on_parse_error segment, got nil")
+	}
+}
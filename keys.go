@@ -0,0 +1,51 @@
+package jsonparse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// bodyKey computes a stable hash of the values at paths in v.
+// Identical values produce the same key regardless of formatting
+// or key order in the original body.
+func bodyKey(v interface{}, paths []string) (string, error) {
+	values := make([]interface{}, len(paths))
+	for i, path := range paths {
+		values[i] = fetchValue(v, path)
+	}
+
+	// json encoding sorts object keys, normalizing the values
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newKeysReplacerFunc returns a replacer func for the
+// {json_parse.key.*} placeholders defined by keys.
+func newKeysReplacerFunc(v interface{}, keys map[string][]string) caddy.ReplacerFunc {
+	return func(key string) (interface{}, bool) {
+		prefix := "json_parse.key."
+		if !strings.HasPrefix(key, prefix) {
+			return nil, false
+		}
+
+		paths, ok := keys[strings.TrimPrefix(key, prefix)]
+		if !ok {
+			return nil, false
+		}
+
+		val, err := bodyKey(v, paths)
+		if err != nil {
+			return nil, false
+		}
+		return val, true
+	}
+}
@@ -0,0 +1,29 @@
+package jsonparse
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBodyKey(t *testing.T) {
+	var a, b interface{}
+	if err := json.Unmarshal([]byte(`{"id": 1, "m": {"x": 1, "y": 2}, "z": 0}`), &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"z": 9, "m": {"y": 2, "x": 1}, "id": 1}`), &b); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{"id", "m"}
+	ka, err := bodyKey(a, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := bodyKey(b, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ka != kb {
+		t.Errorf("want equal keys, got: %v and %v", ka, kb)
+	}
+}
@@ -0,0 +1,91 @@
+package jsonparse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// mirrorTimeout bounds each mirrored request.
+const mirrorTimeout = 10 * time.Second
+
+// maxMirrors bounds the number of mirrored requests in flight.
+// Further requests are not mirrored until one completes, so that
+// a slow shadow upstream cannot pile up goroutines and bodies.
+const maxMirrors = 64
+
+// mirror asynchronously sends copies of request bodies to a shadow
+// upstream. Responses are discarded and failures are only logged.
+type mirror struct {
+	target *url.URL
+	client *http.Client
+	log    *zap.Logger
+	slots  chan struct{}
+}
+
+func newMirror(upstream string, log *zap.Logger) (*mirror, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("mirror upstream must be an absolute URL: %s", upstream)
+	}
+	return &mirror{
+		target: u,
+		client: &http.Client{Timeout: mirrorTimeout},
+		log:    log,
+		slots:  make(chan struct{}, maxMirrors),
+	}, nil
+}
+
+// send mirrors body to the upstream using the method, path
+// and content type of r. It does not block, and drops the
+// copy if too many are in flight.
+func (m *mirror) send(r *http.Request, body []byte) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.log.Debug("mirror: too many requests in flight, dropping")
+		return
+	}
+
+	u := *m.target
+	u.Path = singleJoiningSlash(u.Path, r.URL.Path)
+	u.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(context.Background(), r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		<-m.slots
+		m.log.Debug("mirror", zap.Error(err))
+		return
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+
+	go func() {
+		defer func() { <-m.slots }()
+		resp, err := m.client.Do(req)
+		if err != nil {
+			m.log.Debug("mirror", zap.Error(err))
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+func singleJoiningSlash(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case a[len(a)-1] == '/' && len(b) > 0 && b[0] == '/':
+		return a + b[1:]
+	case a[len(a)-1] != '/' && (len(b) == 0 || b[0] != '/'):
+		return a + "/" + b
+	}
+	return a + b
+}
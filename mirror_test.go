@@ -0,0 +1,72 @@
+package jsonparse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestMirrorSend(t *testing.T) {
+	type mirrored struct {
+		path, contentType, body string
+	}
+	received := make(chan mirrored, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- mirrored{r.URL.RequestURI(), r.Header.Get("Content-Type"), string(body)}
+	}))
+	defer upstream.Close()
+
+	m, err := newMirror(upstream.URL+"/shadow/", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/hook?x=1", nil)
+	r.Header.Set("Content-Type", "application/json")
+	m.send(r, []byte(`{"a":1}`))
+
+	expected := mirrored{"/shadow/hook?x=1", "application/json", `{"a":1}`}
+	select {
+	case got := <-received:
+		if got != expected {
+			t.Errorf("want: %v, got: %v", expected, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want mirrored request")
+	}
+}
+
+func TestMirrorDrop(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	m, err := newMirror(upstream.URL, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	for i := 0; i < maxMirrors+10; i++ {
+		m.send(r, []byte(`{}`))
+	}
+	if n := len(m.slots); n != maxMirrors {
+		t.Errorf("want: %v, got: %v", maxMirrors, n)
+	}
+}
+
+func TestNewMirror(t *testing.T) {
+	for _, upstream := range []string{"shadow:8080", "/path", "http://"} {
+		if _, err := newMirror(upstream, zap.NewNop()); err == nil {
+			t.Errorf("want invalid %s, got nil", upstream)
+		}
+	}
+}
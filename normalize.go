@@ -0,0 +1,33 @@
+package jsonparse
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeString NFC-normalizes s. If strip is set, invisible
+// format characters (e.g. zero-width joiners) and control
+// characters other than whitespace are removed.
+func normalizeString(s string, strip bool) string {
+	if strip {
+		s = strings.Map(func(r rune) rune {
+			if isInvisible(r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	return norm.NFC.String(s)
+}
+
+// isInvisible reports whether r is a format or non-whitespace
+// control character.
+func isInvisible(r rune) bool {
+	switch r {
+	case '\t', '\n', '\r':
+		return false
+	}
+	return unicode.Is(unicode.Cf, r) || unicode.IsControl(r)
+}
@@ -0,0 +1,27 @@
+package jsonparse
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNormalizeString(t *testing.T) {
+	tests := []struct {
+		input    string
+		strip    bool
+		expected string
+	}{
+		{input: "cafe\u0301", expected: "caf\u00e9"},
+		{input: "ad\u200dmin", expected: "ad\u200dmin"},
+		{input: "ad\u200dmin", strip: true, expected: "admin"},
+		{input: "\ufeffa\u0000b\tc", strip: true, expected: "ab\tc"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			if val := normalizeString(tt.input, tt.strip); val != tt.expected {
+				t.Errorf("want: %q, got: %q", tt.expected, val)
+			}
+		})
+	}
+}
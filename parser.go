@@ -2,12 +2,17 @@ package jsonparse
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/caddyserver/caddy/v2"
 )
@@ -62,22 +67,23 @@ func fromArray(v interface{}, key string) (interface{}, bool) {
 	}
 
 	// ensure index
-	if len(a) > i {
+	if i >= 0 && len(a) > i {
 		return a[i], true
 	}
 
 	return nil, true
 }
 
-func fetchValue(v interface{}, key string) interface{} {
-	f := fetchers{
-		fetcherFunc(fromMap),
-		fetcherFunc(fromArray),
-	}
+// valueFetchers fetch a key from objects and arrays.
+var valueFetchers = fetchers{
+	fetcherFunc(fromMap),
+	fetcherFunc(fromArray),
+}
 
+func fetchValue(v interface{}, key string) interface{} {
 	var current interface{} = v
 	for _, k := range strings.Split(key, ".") {
-		val, ok := f.Fetch(current, k)
+		val, ok := valueFetchers.Fetch(current, k)
 		if !ok {
 			return nil
 		}
@@ -87,38 +93,373 @@ func fetchValue(v interface{}, key string) interface{} {
 	return current
 }
 
-func newReplacerFunc(r *http.Request) (caddy.ReplacerFunc, error) {
-	var v interface{}
+// checkPath returns an error if path has empty keys,
+// e.g. "a..b" or a trailing dot, which never match.
+func checkPath(path string) error {
+	for _, k := range strings.Split(path, ".") {
+		if k == "" {
+			return fmt.Errorf("invalid path '%s': empty key", path)
+		}
+	}
+	return nil
+}
+
+// missing is returned by fetchValues for values that do not exist,
+// as opposed to nil for json null.
+var missing = &struct{}{}
 
-	bodyCopy := bytes.Buffer{}
-	tee := io.TeeReader(r.Body, &bodyCopy) // preserve the body
-	err := json.NewDecoder(tee).Decode(&v)
-	if err != nil {
-		return nil, err
+// fetchValues is like fetchValue, but a "*" key matches every
+// element of an array or object. The missing value is returned
+// for each match that does not exist.
+func fetchValues(v interface{}, key string) []interface{} {
+	return fetchPath(v, strings.Split(key, "."))
+}
+
+func fetchPath(v interface{}, keys []string) []interface{} {
+	if len(keys) == 0 {
+		return []interface{}{v}
+	}
+
+	k, rest := keys[0], keys[1:]
+	if k != "*" {
+		val, ok := lookup(v, k)
+		if !ok {
+			return []interface{}{missing}
+		}
+		return fetchPath(val, rest)
+	}
+
+	var values []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, val := range v {
+			values = append(values, fetchPath(val, rest)...)
+		}
+	case []interface{}:
+		for _, val := range v {
+			values = append(values, fetchPath(val, rest)...)
+		}
+	default:
+		return []interface{}{missing}
+	}
+	return values
+}
+
+// lookup returns the value of key in an object or array
+// and whether it exists.
+func lookup(v interface{}, key string) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		val, ok := v[key]
+		return val, ok
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, false
+		}
+		return v[i], true
+	}
+	return nil, false
+}
+
+// bufPool reduces allocations when reading request bodies.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// maxPooledBuffer is the largest buffer returned to bufPool, so that
+// a single huge body does not pin its memory in the pool.
+const maxPooledBuffer = 64 << 10
+
+// putBuffer returns buf to bufPool unless it grew too large.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufPool.Put(buf)
+}
+
+// errEmptyBody is returned when the request has no body to parse.
+var errEmptyBody = errors.New("empty request body")
+
+// readError is returned when the request body could not be read.
+type readError struct {
+	err error
+}
+
+func (e readError) Error() string { return "reading request body: " + e.err.Error() }
+func (e readError) Unwrap() error { return e.err }
+
+// errBodyTooLarge is returned when the body exceeds the max size
+// and is streamed through without parsing.
+var errBodyTooLarge = errors.New("request body too large to parse")
+
+// readBody reads the entire body of r and replaces it with
+// an in-memory copy so that further handlers can read it again.
+// If maxSize is positive, at most maxSize bytes are buffered and
+// larger bodies are left to stream through unparsed.
+func readBody(r *http.Request, maxSize int64) ([]byte, error) {
+	if maxSize > 0 && r.ContentLength > maxSize {
+		return nil, errBodyTooLarge
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer putBuffer(buf)
+
+	var reader io.Reader = ctxReader{ctx: r.Context(), r: r.Body}
+	if maxSize > 0 {
+		// read one extra byte to detect bodies of unknown length
+		// exceeding the limit.
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	_, err := buf.ReadFrom(reader)
+
+	// the pooled buffer is reused, the body needs its own copy
+	body := make([]byte, buf.Len())
+	copy(body, buf.Bytes())
+
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		// stitch the buffered prefix back to the unread remainder
+		r.Body = readCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), r.Body),
+			Closer: r.Body,
+		}
+		return nil, errBodyTooLarge
 	}
 
 	// replace the body for further handlers
-	r.Body = ioutil.NopCloser(&bodyCopy)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
 
+	return body, err
+}
+
+// isJSONContentType reports whether the Content-Type header h
+// declares a json body, i.e. application/json or a +json media type.
+func isJSONContentType(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// expectsContinue reports whether the client sent Expect: 100-continue.
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// dropBody empties the body of r and removes the
+// headers describing it.
+func dropBody(r *http.Request) {
+	r.Body = http.NoBody
+	r.ContentLength = 0
+	r.TransferEncoding = nil
+	r.Header.Del("Content-Length")
+	r.Header.Del("Content-Type")
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-MD5")
+	r.Header.Del("Digest")
+}
+
+// ctxReader stops reading once ctx is done, e.g. when the
+// client disconnects or a route timeout elapses.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ctxKey is the type of context keys set by this module.
+type ctxKey string
+
+// documentCtxKey is the context key for the parsed request body.
+// Other sources have their own keys.
+const documentCtxKey ctxKey = "json_parse_document"
+
+// document is a parsed request body. It is stored in the request
+// context so that multiple json_parse handlers in the same request
+// do not read and parse the body more than once.
+type document struct {
+	raw   []byte
+	value interface{}
+	err   error
+	opts  parseOptions
+}
+
+// parseDocument parses the json source of r, the body by default.
+// If the source was already parsed earlier in the request, the stored
+// document is returned and fresh is false. A document parsed with
+// other decoding options is decoded again from its raw json, and
+// is only fresh if the earlier one failed to parse.
+func parseDocument(r *http.Request, opts parseOptions) (doc *document, req *http.Request, fresh bool) {
+	key := opts.source.ctxKey()
+	prev, ok := r.Context().Value(key).(*document)
+	if ok && prev.opts.equal(opts) {
+		return prev, r, false
+	}
+
+	doc = &document{opts: opts}
+	if ok && prev.err != errBodyTooLarge {
+		doc.raw, doc.err = prev.raw, prev.err
+		var re readError
+		if !errors.As(doc.err, &re) {
+			doc.err = doc.decode(r)
+		}
+	} else {
+		doc.raw, doc.err = opts.source.read(r, opts.maxSize)
+		if doc.err != nil && doc.err != errBodyTooLarge {
+			doc.err = readError{doc.err}
+		} else if doc.err == nil {
+			doc.err = doc.decode(r)
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), key, doc)
+	return doc, r.WithContext(ctx), !ok || prev.err != nil
+}
+
+// decode parses the raw json of doc with its options.
+func (doc *document) decode(r *http.Request) error {
+	var err error
+	switch {
+	case r.Context().Err() != nil:
+		// no need to parse for a request that is gone
+		return readError{r.Context().Err()}
+	case doc.opts.maxSize > 0 && int64(len(doc.raw)) > doc.opts.maxSize:
+		return errBodyTooLarge
+	case len(bytes.TrimSpace(doc.raw)) == 0:
+		return errEmptyBody
+	default:
+		doc.value, err = decodeContext(r.Context(), doc.raw, doc.opts)
+	}
+	if err != nil && r.Context().Err() != nil {
+		// the request went away while parsing
+		return readError{r.Context().Err()}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("max_processing_time of %v exceeded", doc.opts.maxTime)
+	}
+	return err
+}
+
+// RawBody returns the request body exactly as the client sent it,
+// if a json_parse handler earlier in the chain read it.
+func RawBody(r *http.Request) ([]byte, bool) {
+	doc, ok := r.Context().Value(documentCtxKey).(*document)
+	if !ok || doc.raw == nil {
+		return nil, false
+	}
+	return doc.raw, true
+}
+
+// newRawReplacerFunc returns a replacer func for the
+// {json_parse.raw} placeholder holding the original body.
+func newRawReplacerFunc(raw []byte) caddy.ReplacerFunc {
+	return func(key string) (interface{}, bool) {
+		if key != "json_parse.raw" {
+			return nil, false
+		}
+		return string(raw), true
+	}
+}
+
+// newReplacerFunc returns a replacer func for the placeholders
+// with prefix, e.g. {json.*}, and for the root value, e.g. {json}
+// for array and scalar bodies. It is safe for concurrent use, e.g. by handlers
+// running after the response, and objects and arrays are returned
+// as copies so that callers cannot modify the parsed body.
+func newReplacerFunc(prefix string, v interface{}) caddy.ReplacerFunc {
 	// prevent repetitive parsing. cache values
 	values := map[string]interface{}{}
+	var mu sync.Mutex
 
 	return func(key string) (interface{}, bool) {
-		prefix := "json."
+		if key == strings.TrimSuffix(prefix, ".") {
+			return rootValue(v), true
+		}
 		if !strings.HasPrefix(key, prefix) {
 			return nil, false
 		}
 		key = strings.TrimPrefix(key, prefix)
 
+		mu.Lock()
+		defer mu.Unlock()
+
 		// use cache if previously fetched
 		if val, ok := values[key]; ok {
-			return val, true
+			return deepCopy(val), true
 		}
 
-		val := fetchValue(v, key)
+		val, ok := aggregate(v, key)
+		if !ok {
+			val = fetchValue(v, key)
+		}
 		values[key] = val // cache
 
-		return val, true
+		return deepCopy(val), true
+	}
+}
+
+// rootValue returns the value of the root placeholder, e.g. {json}.
+// Objects and arrays are copied and rendered as json in strings,
+// while json_respond templates still emit them as json values.
+func rootValue(v interface{}) interface{} {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return jsonRoot{deepCopy(v)}
+	}
+	return v
+}
 
-	}, nil
+// jsonRoot is an object or array root that is rendered as json
+// rather than as a Go value.
+type jsonRoot struct{ v interface{} }
+
+// String implements fmt.Stringer.
+func (r jsonRoot) String() string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r.v); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r jsonRoot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.v)
+}
+
+// deepCopy returns a copy of the objects and arrays in v.
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[key] = deepCopy(val)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, val := range v {
+			a[i] = deepCopy(val)
+		}
+		return a
+	}
+	return v
 }
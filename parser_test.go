@@ -1,8 +1,13 @@
 package jsonparse
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -42,6 +47,21 @@ func TestFetchers(t *testing.T) {
 			key:      "ref.joe.2.sum.100.dave",
 			expected: "lee",
 		},
+		{
+			json:     `[{"method":"eth_call","url":"http://a"}]`,
+			key:      "0.url",
+			expected: "http://a",
+		},
+		{
+			json:     `[7,8,9]`,
+			key:      "-1",
+			expected: nil,
+		},
+		{
+			json:     `"scalar"`,
+			key:      "0",
+			expected: nil,
+		},
 	}
 
 	for i, tt := range tests {
@@ -60,3 +80,193 @@ func TestFetchers(t *testing.T) {
 	}
 
 }
+
+func TestReadBody(t *testing.T) {
+	const body = `{"ref":"ok"}`
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+
+	b, err := readBody(r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("want: %v, got: %v", body, string(b))
+	}
+
+	// body must remain readable for further handlers
+	b, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("want: %v, got: %v", body, string(b))
+	}
+}
+
+func TestParseDocumentReuse(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"ref":"ok"}`))
+
+	doc, r, fresh := parseDocument(r, parseOptions{})
+	if doc.err != nil {
+		t.Fatal(doc.err)
+	}
+	if !fresh {
+		t.Error("want fresh document on first parse")
+	}
+
+	again, _, fresh := parseDocument(r, parseOptions{})
+	if fresh {
+		t.Error("want stored document on second parse")
+	}
+	if again != doc {
+		t.Error("want the same document on second parse")
+	}
+}
+
+func TestParseDocumentOptions(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"role":"user","role":"admin"}`))
+
+	doc, r, _ := parseDocument(r, parseOptions{})
+	if doc.err != nil {
+		t.Fatal(doc.err)
+	}
+
+	// a handler rejecting duplicate keys must not reuse the
+	// document of a handler keeping the last value.
+	strict, r, fresh := parseDocument(r, parseOptions{duplicateKeys: duplicateReject})
+	if fresh {
+		t.Error("want no fresh placeholders for a decoded document")
+	}
+	if strict.err == nil {
+		t.Error("want error, got nil")
+	}
+
+	small, _, _ := parseDocument(r, parseOptions{maxSize: 8})
+	if small.err != errBodyTooLarge {
+		t.Errorf("want: %v, got: %v", errBodyTooLarge, small.err)
+	}
+}
+
+func TestReadBodyMaxSize(t *testing.T) {
+	const body = `{"ref":"ok"}`
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.ContentLength = -1 // unknown length, e.g. chunked
+
+	if _, err := readBody(r, 4); err != errBodyTooLarge {
+		t.Errorf("want: %v, got: %v", errBodyTooLarge, err)
+	}
+
+	// the full body must still be available downstream
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("want: %v, got: %v", body, string(b))
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	for _, path := range []string{"ref", "items.0.id", "items.*.id"} {
+		if err := checkPath(path); err != nil {
+			t.Errorf("want valid %s, got: %v", path, err)
+		}
+	}
+	for _, path := range []string{"", "a..b", "a.", ".a"} {
+		if err := checkPath(path); err == nil {
+			t.Errorf("want invalid %s, got nil", path)
+		}
+	}
+}
+
+func TestReadBodyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, maxSize := range []int64{0, 1024} {
+		t.Run(fmt.Sprint(maxSize), func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(`{"ref":"ok"}`))
+			r = r.WithContext(ctx)
+
+			if _, err := readBody(r, maxSize); err != context.Canceled {
+				t.Errorf("want: %v, got: %v", context.Canceled, err)
+			}
+		})
+	}
+}
+
+func TestRawBody(t *testing.T) {
+	const body = `{"ref": "ok"}`
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+
+	if _, ok := RawBody(r); ok {
+		t.Error("want no raw body before parsing")
+	}
+
+	_, r, _ = parseDocument(r, parseOptions{})
+	raw, ok := RawBody(r)
+	if !ok {
+		t.Fatal("want raw body after parsing")
+	}
+	if string(raw) != body {
+		t.Errorf("want: %v, got: %v", body, string(raw))
+	}
+}
+
+func TestReplacerFuncSnapshot(t *testing.T) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"items": [{"id": 1}]}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	repl := newReplacerFunc("json.", v)
+
+	val, _ := repl("json.items")
+	val.([]interface{})[0].(map[string]interface{})["id"] = "changed"
+
+	if val, _ := repl("json.items.0.id"); val != float64(1) {
+		t.Errorf("want: %v, got: %v", float64(1), val)
+	}
+	if val := fetchValue(v, "items.0.id"); val != float64(1) {
+		t.Errorf("want: %v, got: %v", float64(1), val)
+	}
+}
+
+func TestReplacerFuncRoot(t *testing.T) {
+	tests := []struct {
+		json     string
+		key      string
+		expected string
+	}{
+		{json: `"scalar"`, key: "json", expected: "scalar"},
+		{json: `42`, key: "json", expected: "42"},
+		{json: `[1,2]`, key: "json", expected: "[1,2]"},
+		{json: `{"a":{"b":true}}`, key: "json", expected: `{"a":{"b":true}}`},
+		{json: `["<a&b>"]`, key: "json", expected: `["<a&b>"]`},
+		{json: `[{"id":1},{"id":2}]`, key: "json.1.id", expected: "2"},
+		{json: `[{"id":1},{"id":2}]`, key: "json.count.*", expected: "2"},
+		{json: `[{"id":1},{"id":2}]`, key: "json.sum.*.id", expected: "3"},
+		{json: `[1,2]`, key: "jsonx", expected: "<nil>"},
+	}
+
+	for i, tt := range tests {
+		var v interface{}
+		if err := json.Unmarshal([]byte(tt.json), &v); err != nil {
+			t.Fatal(err)
+		}
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			val, _ := newReplacerFunc("json.", v)(tt.key)
+			if got := fmt.Sprint(val); got != tt.expected {
+				t.Errorf("want: %v, got: %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPutBuffer(t *testing.T) {
+	large := bytes.NewBuffer(make([]byte, 0, maxPooledBuffer+1))
+	putBuffer(large)
+
+	if got := bufPool.Get().(*bytes.Buffer); got == large {
+		t.Error("want large buffer not pooled")
+	}
+}
@@ -0,0 +1,55 @@
+package jsonparse
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// regexCacheSize is the number of compiled dynamic
+// regexes kept per validation.
+const regexCacheSize = 64
+
+// regexCache is a least recently used cache of compiled regexes.
+type regexCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type regexEntry struct {
+	pattern string
+	regex   *regexp.Regexp
+}
+
+func newRegexCache() *regexCache {
+	return &regexCache{
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// compile returns the compiled pattern, compiling it if it is
+// not cached.
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*regexEntry).regex, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.entries[pattern] = c.order.PushFront(&regexEntry{pattern: pattern, regex: re})
+	if c.order.Len() > regexCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*regexEntry).pattern)
+	}
+	return re, nil
+}
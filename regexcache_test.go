@@ -0,0 +1,39 @@
+package jsonparse
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRegexCache(t *testing.T) {
+	c := newRegexCache()
+
+	first, err := c.compile("^a$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := c.compile("^a$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != again {
+		t.Error("want cached regex on second compile")
+	}
+
+	// evict the oldest entries
+	for i := 0; i < regexCacheSize; i++ {
+		if _, err := c.compile(fmt.Sprintf("^%d$", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := c.entries["^a$"]; ok {
+		t.Error("want ^a$ evicted")
+	}
+	if c.order.Len() != regexCacheSize {
+		t.Errorf("want: %v, got: %v", regexCacheSize, c.order.Len())
+	}
+
+	if _, err := c.compile("("); err == nil {
+		t.Error("want error, got nil")
+	}
+}
@@ -0,0 +1,188 @@
+package jsonparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*JSONRespond)(nil)
+	_ caddyhttp.MiddlewareHandler = (*JSONRespond)(nil)
+	_ caddyfile.Unmarshaler       = (*JSONRespond)(nil)
+)
+
+func init() {
+	caddy.RegisterModule(JSONRespond{})
+	httpcaddyfile.RegisterHandlerDirective("json_respond", parseRespondCaddyfile)
+}
+
+// JSONRespond implements an HTTP handler that responds with
+// json built from a template containing placeholders.
+type JSONRespond struct {
+	// Template is the json response. Placeholders in string values
+	// are replaced; a string consisting of a single placeholder is
+	// replaced by the placeholder's value, preserving its json type.
+	Template json.RawMessage `json:"template,omitempty"`
+
+	// TemplateFile is the path to a file containing the template,
+	// read and validated at provision. Mutually exclusive with Template.
+	TemplateFile string `json:"template_file,omitempty"`
+
+	// StatusCode is the response status. Default is 200.
+	StatusCode int `json:"status_code,omitempty"`
+
+	template interface{}
+}
+
+// CaddyModule returns the Caddy module information.
+func (JSONRespond) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.json_respond",
+		New: func() caddy.Module { return new(JSONRespond) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (j *JSONRespond) Provision(ctx caddy.Context) error {
+	if err := checkStatus(j.StatusCode); err != nil {
+		return fmt.Errorf("json_respond: %v", err)
+	}
+
+	if j.TemplateFile != "" {
+		if len(j.Template) > 0 {
+			return fmt.Errorf("json_respond: template and template_file are mutually exclusive")
+		}
+		b, err := ioutil.ReadFile(j.TemplateFile)
+		if err != nil {
+			return fmt.Errorf("json_respond: reading template: %v", err)
+		}
+		j.Template = b
+	}
+
+	if len(j.Template) == 0 {
+		return fmt.Errorf("json_respond: template is required")
+	}
+	// numbers keep their literal text, e.g. large integers
+	tmpl, err := decodeTree(ctx, j.Template, parseOptions{exactNumbers: true})
+	if err != nil {
+		return fmt.Errorf("json_respond: invalid template: %v", err)
+	}
+	j.template = tmpl
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (j JSONRespond) ServeHTTP(w http.ResponseWriter, r *http.Request, _ caddyhttp.Handler) error {
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+	b, err := json.Marshal(expandTemplate(repl, j.template))
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	status := j.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+// expandTemplate returns a copy of v with placeholders replaced.
+func expandTemplate(repl *caddy.Replacer, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[repl.ReplaceAll(key, "")] = expandTemplate(repl, val)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, val := range v {
+			a[i] = expandTemplate(repl, val)
+		}
+		return a
+	case string:
+		// a lone placeholder keeps the type of its value
+		if isPlaceholder(v) {
+			val, _ := repl.Get(v[1 : len(v)-1])
+			return val
+		}
+		return repl.ReplaceAll(v, "")
+	}
+	return v
+}
+
+// isPlaceholder reports whether s is exactly one placeholder.
+func isPlaceholder(s string) bool {
+	return len(s) > 2 &&
+		s[0] == '{' && s[len(s)-1] == '}' &&
+		strings.Count(s, "{") == 1 && strings.Count(s, "}") == 1
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (j *JSONRespond) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		args := d.RemainingArgs()
+		switch len(args) {
+		case 2:
+			status, err := strconv.Atoi(args[1])
+			if err != nil {
+				return d.Errf("invalid status code '%s': %v", args[1], err)
+			}
+			j.StatusCode = status
+			fallthrough
+		case 1:
+			if !json.Valid([]byte(args[0])) {
+				return d.Errf("invalid template '%s'", args[0])
+			}
+			j.Template = json.RawMessage(args[0])
+		case 0:
+		default:
+			return d.ArgErr()
+		}
+
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "template_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				j.TemplateFile = d.Val()
+			case "status":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				status, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid status code '%s': %v", d.Val(), err)
+				}
+				j.StatusCode = status
+			default:
+				return d.Errf("unrecognized subdirective '%s'", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// parseRespondCaddyfile unmarshals tokens from h into a new JSONRespond.
+func parseRespondCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m JSONRespond
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	return m, err
+}
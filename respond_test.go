@@ -0,0 +1,167 @@
+package jsonparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	repl := caddy.NewReplacer()
+	repl.Set("json.id", float64(7))
+	repl.Set("json.name", "joe")
+
+	var tmpl interface{}
+	err := json.Unmarshal([]byte(`{"id": "{json.id}", "msg": "hi {json.name}", "list": ["{json.missing}"]}`), &tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(expandTemplate(repl, tmpl))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"id":7,"list":[null],"msg":"hi joe"}`
+	if string(b) != expected {
+		t.Errorf("want: %v, got: %v", expected, string(b))
+	}
+}
+
+func TestExpandTemplateRoot(t *testing.T) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"items":[1,2]}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	repl := caddy.NewReplacer()
+	repl.Map(newReplacerFunc("json.", v))
+
+	var tmpl interface{}
+	err := json.Unmarshal([]byte(`{"echo": "{json}", "items": "{json.items}", "msg": "got {json}"}`), &tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(expandTemplate(repl, tmpl))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"echo":{"items":[1,2]},"items":[1,2],"msg":"got {\"items\":[1,2]}"}`
+	if string(b) != expected {
+		t.Errorf("want: %v, got: %v", expected, string(b))
+	}
+}
+
+func TestRespondProvisionNumbers(t *testing.T) {
+	j := JSONRespond{Template: json.RawMessage(`{"id": 9007199254740993, "price": 1.50}`)}
+	if err := j.Provision(caddy.Context{}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(expandTemplate(caddy.NewReplacer(), j.template))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"id":9007199254740993,"price":1.50}`
+	if string(b) != expected {
+		t.Errorf("want: %v, got: %v", expected, string(b))
+	}
+}
+
+func TestRespondProvisionStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		valid  bool
+	}{
+		{status: 0, valid: true},
+		{status: http.StatusCreated, valid: true},
+		{status: 42},
+		{status: 1000},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := JSONRespond{Template: json.RawMessage(`{}`), StatusCode: tt.status}
+			if err := j.Provision(caddy.Context{}); (err == nil) != tt.valid {
+				t.Errorf("want: %v, got: %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestRespondUnmarshalCaddyfile(t *testing.T) {
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{input: `json_respond {"id":"{json.id}"}`, valid: true},
+		{input: `json_respond {"id":"{json.id}"} 201`, valid: true},
+		{input: `json_respond {"id":`, valid: false},
+		{input: `json_respond {"id":1} abc`, valid: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			var j JSONRespond
+			err := j.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input))
+			if (err == nil) != tt.valid {
+				t.Errorf("want valid: %v, got: %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestRespondTemplateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json_respond")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.json")
+	if err := ioutil.WriteFile(valid, []byte(`{"id": "{json.id}"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(`{"id":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		handler JSONRespond
+		err     string
+	}{
+		{handler: JSONRespond{TemplateFile: valid}},
+		{handler: JSONRespond{TemplateFile: valid, Template: json.RawMessage(`{}`)}, err: "mutually exclusive"},
+		{handler: JSONRespond{TemplateFile: filepath.Join(dir, "missing.json")}, err: "reading template"},
+		{handler: JSONRespond{TemplateFile: invalid}, err: "invalid template"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			err := tt.handler.Provision(caddy.Context{})
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id := tt.handler.template.(map[string]interface{})["id"]; id != "{json.id}" {
+					t.Errorf("want: %v, got: %v", "{json.id}", id)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("want: %v, got: %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package jsonparse
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// defaultResponseMaxSize bounds buffered responses
+// if MaxSize is not set.
+const defaultResponseMaxSize = 1 << 20
+
+// serveNext calls next, buffering and parsing json responses
+// into {json_resp.*} placeholders if ParseResponse is set.
+func (j JSONParse) serveNext(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if !j.ParseResponse {
+		return next.ServeHTTP(w, r)
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer putBuffer(buf)
+
+	rb := &responseBuffer{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		buf:                   buf,
+		max:                   j.responseMaxSize(),
+		shouldBuffer:          j.shouldBufferResponse,
+	}
+	if err := next.ServeHTTP(rb, r); err != nil {
+		return err
+	}
+	if !rb.buffering {
+		return nil
+	}
+
+	opts := j.parseOptions()
+	opts.embedded = nil
+	v, err := decode(buf.Bytes(), opts)
+	if err != nil {
+		j.log.Debug("parsing response", zap.Error(err))
+	} else {
+		repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		repl.Map(newReplacerFunc("json_resp.", v))
+	}
+
+	return rb.passThrough()
+}
+
+// responseMaxSize returns the maximum size of responses to parse.
+func (j JSONParse) responseMaxSize() int {
+	if j.MaxSize > 0 {
+		return int(j.MaxSize)
+	}
+	return defaultResponseMaxSize
+}
+
+// shouldBufferResponse reports whether a response is json and
+// not declared larger than the maximum size.
+func (j JSONParse) shouldBufferResponse(status int, header http.Header) bool {
+	if status == http.StatusNoContent || !isJSONContentType(header) {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && n > int64(j.responseMaxSize()) {
+		return false
+	}
+	return true
+}
+
+// responseBuffer buffers a response for parsing. Responses growing
+// beyond max bytes, e.g. chunked ones without a Content-Length, are
+// passed through unparsed from then on.
+type responseBuffer struct {
+	*caddyhttp.ResponseWriterWrapper
+	buf          *bytes.Buffer
+	max          int
+	shouldBuffer func(status int, header http.Header) bool
+
+	status      int
+	wroteHeader bool
+	buffering   bool
+}
+
+func (rb *responseBuffer) WriteHeader(status int) {
+	if rb.wroteHeader {
+		return
+	}
+	rb.status, rb.wroteHeader = status, true
+	rb.buffering = rb.shouldBuffer(status, rb.Header())
+	if !rb.buffering {
+		rb.ResponseWriterWrapper.WriteHeader(status)
+	}
+}
+
+func (rb *responseBuffer) Write(p []byte) (int, error) {
+	rb.WriteHeader(http.StatusOK)
+	if rb.buffering && rb.buf.Len()+len(p) > rb.max {
+		if err := rb.passThrough(); err != nil {
+			return 0, err
+		}
+	}
+	if rb.buffering {
+		return rb.buf.Write(p)
+	}
+	return rb.ResponseWriterWrapper.Write(p)
+}
+
+// Flush passes the response through, as flushing handlers
+// stream their responses.
+func (rb *responseBuffer) Flush() {
+	if rb.buffering {
+		if err := rb.passThrough(); err != nil {
+			return
+		}
+	}
+	rb.ResponseWriterWrapper.Flush()
+}
+
+// passThrough stops buffering and writes the response
+// buffered so far.
+func (rb *responseBuffer) passThrough() error {
+	rb.buffering = false
+	rb.ResponseWriterWrapper.WriteHeader(rb.status)
+	_, err := rb.ResponseWriterWrapper.Write(rb.buf.Bytes())
+	rb.buf.Reset()
+	return err
+}
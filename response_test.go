@@ -0,0 +1,59 @@
+package jsonparse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func TestServeNext(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		{contentType: "application/json", body: `{"user":{"id":7}}`, want: "7"},
+		{contentType: "application/problem+json", body: `{"user":{"id":"a"}}`, want: "a"},
+		{contentType: "text/plain", body: `{"user":{"id":7}}`, want: ""},
+		{contentType: "application/json", body: `{"user":`, want: ""},
+		{contentType: "application/json", body: `{"user":{"id":7},"pad":"` + strings.Repeat("x", 64) + `"}`, want: ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			j := JSONParse{ParseResponse: true, MaxSize: 64, log: zap.NewNop()}
+			repl := caddy.NewReplacer()
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+			w := httptest.NewRecorder()
+
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				// written in chunks without a Content-Length
+				w.Header().Set("Content-Type", tt.contentType)
+				for _, chunk := range []string{tt.body[:len(tt.body)/2], tt.body[len(tt.body)/2:]} {
+					if _, err := w.Write([]byte(chunk)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err := j.serveNext(w, r, next); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := repl.ReplaceAll("{json_resp.user.id}", ""); got != tt.want {
+				t.Errorf("want: %v, got: %v", tt.want, got)
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("want: %v, got: %v", tt.body, got)
+			}
+		})
+	}
+}
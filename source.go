@@ -0,0 +1,128 @@
+package jsonparse
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Source kinds.
+const (
+	sourceBody   = "body"
+	sourceHeader = "header"
+	sourceCookie = "cookie"
+)
+
+// source is where the json to parse is read from.
+// The zero value is the request body.
+type source struct {
+	kind string
+	name string
+}
+
+// parseSource parses a source of the form "body", "header:<name>"
+// or "cookie:<name>".
+func parseSource(s string) (source, error) {
+	if s == "" || s == sourceBody {
+		return source{}, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return source{}, fmt.Errorf("invalid source '%s'", s)
+	}
+
+	switch parts[0] {
+	case sourceHeader:
+		return source{kind: parts[0], name: http.CanonicalHeaderKey(parts[1])}, nil
+	case sourceCookie:
+		return source{kind: parts[0], name: parts[1]}, nil
+	}
+	return source{}, fmt.Errorf("unknown source '%s'", parts[0])
+}
+
+// isBody reports whether s is the request body.
+func (s source) isBody() bool {
+	return s.kind == "" || s.kind == sourceBody
+}
+
+// ctxKey returns the context key of documents parsed from s.
+func (s source) ctxKey() ctxKey {
+	if s.isBody() {
+		return documentCtxKey
+	}
+	return ctxKey("json_parse_document:" + s.kind + ":" + s.name)
+}
+
+// placeholderPrefix returns the prefix of the placeholders of
+// values parsed from s: "json." for the body, and
+// "json_header.<name>." or "json_cookie.<name>." otherwise, so
+// that the placeholders of different sources do not shadow
+// each other.
+func (s source) placeholderPrefix() string {
+	if s.isBody() {
+		return "json."
+	}
+	return "json_" + s.kind + "." + s.name + "."
+}
+
+// read returns the raw json of s from r.
+func (s source) read(r *http.Request, maxSize int64) ([]byte, error) {
+	switch s.kind {
+	case sourceHeader:
+		return []byte(r.Header.Get(s.name)), nil
+	case sourceCookie:
+		c, err := r.Cookie(s.name)
+		if err != nil {
+			return nil, nil
+		}
+		return cookieJSON(c.Value), nil
+	}
+	return readBody(r, maxSize)
+}
+
+// cookieJSON returns the json in a cookie value, which is
+// commonly URL-encoded or base64-encoded.
+func cookieJSON(value string) []byte {
+	// base64 is tried before URL decoding, which would
+	// turn the + of standard base64 into spaces.
+	if b, ok := decodeCookie(value); ok {
+		return b
+	}
+	if v, err := url.QueryUnescape(value); err == nil {
+		if b, ok := decodeCookie(v); ok {
+			return b
+		}
+		value = v
+	}
+
+	// leave it to the decoder to report
+	return []byte(value)
+}
+
+// decodeCookie returns value, or value decoded from base64,
+// if it holds a json object or array.
+func decodeCookie(value string) ([]byte, bool) {
+	trimmed := strings.TrimSpace(value)
+	if isJSONContainer(trimmed) {
+		return []byte(value), true
+	}
+
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	} {
+		if b, err := enc.DecodeString(trimmed); err == nil && isJSONContainer(strings.TrimSpace(string(b))) {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func isJSONContainer(s string) bool {
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
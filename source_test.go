@@ -0,0 +1,93 @@
+package jsonparse
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderSource(t *testing.T) {
+	src, err := parseSource("header:x-device-info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"ref": "body"}`))
+	r.Header.Set("X-Device-Info", `{"os": {"name": "linux"}}`)
+
+	doc, r, _ := parseDocument(r, parseOptions{source: src})
+	if doc.err != nil {
+		t.Fatal(doc.err)
+	}
+	if val := fetchValue(doc.value, "os.name"); val != "linux" {
+		t.Errorf("want: %v, got: %v", "linux", val)
+	}
+
+	// the body is parsed independently
+	doc, _, fresh := parseDocument(r, parseOptions{})
+	if !fresh {
+		t.Error("want fresh document for the body")
+	}
+	if val := fetchValue(doc.value, "ref"); val != "body" {
+		t.Errorf("want: %v, got: %v", "body", val)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	for _, s := range []string{"", "body", "header:X-Claims", "cookie:prefs"} {
+		if _, err := parseSource(s); err != nil {
+			t.Errorf("want valid %s, got: %v", s, err)
+		}
+	}
+	for _, s := range []string{"header", "header:", "query:q"} {
+		if _, err := parseSource(s); err == nil {
+			t.Errorf("want invalid %s, got nil", s)
+		}
+	}
+}
+
+func TestPlaceholderPrefix(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{source: "", expected: "json."},
+		{source: "body", expected: "json."},
+		{source: "header:x-device-info", expected: "json_header.X-Device-Info."},
+		{source: "cookie:prefs", expected: "json_cookie.prefs."},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			src, err := parseSource(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val := src.placeholderPrefix(); val != tt.expected {
+				t.Errorf("want: %v, got: %v", tt.expected, val)
+			}
+		})
+	}
+}
+
+func TestCookieJSON(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: `{"a":1}`, expected: `{"a":1}`},
+		{value: `%7B%22a%22%3A1%7D`, expected: `{"a":1}`},
+		{value: `eyJhIjoxfQ==`, expected: `{"a":1}`},
+		{value: `eyJhIjoxfQ`, expected: `{"a":1}`},
+		{value: `eyJhIjoifn5+In0=`, expected: `{"a":"~~~"}`},
+		{value: `eyJhIjoifn5%2BIn0%3D`, expected: `{"a":"~~~"}`},
+		{value: `true`, expected: `true`},
+	}
+
+	for _, tt := range tests {
+		if val := string(cookieJSON(tt.value)); val != tt.expected {
+			t.Errorf("want: %v, got: %v", tt.expected, val)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package jsonparse
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// privateNets are address ranges not reachable from the internet.
+var privateNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"::/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupIP resolves hostnames for the url guard.
+var lookupIP = net.DefaultResolver.LookupIPAddr
+
+// checkURL returns an error if s is not an absolute url whose host
+// matches allowHosts, if any, and, if denyPrivate is set, does not
+// resolve to a private address.
+func checkURL(ctx context.Context, s string, allowHosts []string, denyPrivate bool) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+
+	if len(allowHosts) > 0 && !matchHost(allowHosts, host) {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+
+	if !denyPrivate {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("host %s is a private address", host)
+		}
+		return nil
+	}
+
+	addrs, err := lookupIP(ctx, host)
+	if err != nil {
+		return fmt.Errorf("resolving host %s: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return fmt.Errorf("host %s resolves to a private address", host)
+		}
+	}
+	return nil
+}
+
+// matchHost reports whether host is in hosts. A "*." prefix
+// matches any subdomain.
+func matchHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if h == host {
+			return true
+		}
+		if strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package jsonparse
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIP = f }(lookupIP)
+	lookupIP = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "internal.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.5")}}, nil
+		case "files.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+
+	tests := []struct {
+		url         string
+		allowHosts  []string
+		denyPrivate bool
+		valid       bool
+	}{
+		{url: "https://files.example.com/a.iso", denyPrivate: true, valid: true},
+		{url: "https://internal.example.com/", denyPrivate: true, valid: false},
+		{url: "http://127.0.0.1:6800/jsonrpc", denyPrivate: true, valid: false},
+		{url: "http://[::1]/", denyPrivate: true, valid: false},
+		{url: "http://169.254.169.254/latest", denyPrivate: true, valid: false},
+		{url: "http://unknown.invalid/", denyPrivate: true, valid: false},
+		{url: "http://127.0.0.1/", valid: true},
+		{url: "/relative", valid: false},
+		{url: "https://files.example.com/", allowHosts: []string{"*.example.com"}, valid: true},
+		{url: "https://example.com.evil/", allowHosts: []string{"*.example.com"}, valid: false},
+		{url: "https://FILES.example.com./", allowHosts: []string{"files.example.com"}, valid: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			err := checkURL(context.Background(), tt.url, tt.allowHosts, tt.denyPrivate)
+			if (err == nil) != tt.valid {
+				t.Errorf("want valid: %v, got: %v", tt.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package jsonparse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// Validation rejects requests whose body does not satisfy
+// the constraints on the value at Path.
+type Validation struct {
+	// Path is the path to the value to validate. A "*" key
+	// validates every element of an array or object.
+	Path string `json:"path,omitempty"`
+
+	// Required rejects bodies missing the value.
+	Required bool `json:"required,omitempty"`
+
+	// Regex must match the value. Missing values are not checked.
+	// Depending on the null policy, null is a missing value.
+	// Placeholders, e.g. {http.request.header.X-Pattern}, are
+	// replaced per request.
+	Regex string `json:"regex,omitempty"`
+
+	// Values lists the allowed values. Values are compared
+	// by their text, e.g. "1" allows the number 1.
+	Values []string `json:"values,omitempty"`
+
+	// Min and Max bound numeric values.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+
+	// MinLength and MaxLength bound the number of characters
+	// of strings and the number of elements of arrays.
+	MinLength *int `json:"min_length,omitempty"`
+	MaxLength *int `json:"max_length,omitempty"`
+
+	// AllowHosts, if set, rejects url values whose host is not
+	// listed. A "*." prefix matches any subdomain.
+	AllowHosts []string `json:"allow_hosts,omitempty"`
+
+	// DenyPrivate rejects url values whose host is, or resolves
+	// to, a private, loopback or link-local address.
+	DenyPrivate bool `json:"deny_private,omitempty"`
+
+	// StatusCode is the response status on failure. Default is 400.
+	StatusCode int `json:"status_code,omitempty"`
+
+	// Message is the error message on failure, available as
+	// {http.error.message}. Placeholders are replaced.
+	Message string `json:"message,omitempty"`
+
+	regex   *regexp.Regexp
+	dynamic *regexCache
+}
+
+// placeholderRegex matches placeholders, but not regex
+// quantifiers such as {2,3}.
+var placeholderRegex = regexp.MustCompile(`\{[A-Za-z_][\w.-]*\.[\w.-]+\}`)
+
+// parseConstraints sets the bounds of v from min=, max=, minlen=
+// and maxlen= arguments.
+func (v *Validation) parseConstraints(args []string) error {
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid constraint '%s'", arg)
+		}
+
+		switch parts[0] {
+		case "min", "max":
+			f, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s '%s': %v", parts[0], parts[1], err)
+			}
+			if parts[0] == "min" {
+				v.Min = &f
+			} else {
+				v.Max = &f
+			}
+		case "minlen", "maxlen":
+			n, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return fmt.Errorf("invalid %s '%s': %v", parts[0], parts[1], err)
+			}
+			if parts[0] == "minlen" {
+				v.MinLength = &n
+			} else {
+				v.MaxLength = &n
+			}
+		default:
+			return fmt.Errorf("unknown constraint '%s'", parts[0])
+		}
+	}
+	return nil
+}
+
+// parseURLGuard sets the url checks of v from deny_private
+// and allow <hosts...> arguments.
+func (v *Validation) parseURLGuard(args []string) error {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "deny_private":
+			v.DenyPrivate = true
+		case "allow":
+			if i == len(args)-1 {
+				return fmt.Errorf("allow requires at least one host")
+			}
+			v.AllowHosts = append(v.AllowHosts, args[i+1:]...)
+			return nil
+		default:
+			return fmt.Errorf("unexpected token '%s'", args[i])
+		}
+	}
+	return nil
+}
+
+// provision compiles the validation.
+func (v *Validation) provision() error {
+	if v.Path == "" {
+		return fmt.Errorf("validation: path is required")
+	}
+	if err := checkPath(v.Path); err != nil {
+		return fmt.Errorf("validation: %v", err)
+	}
+	if err := checkStatus(v.StatusCode); err != nil {
+		return fmt.Errorf("validation %s: %v", v.Path, err)
+	}
+	if placeholderRegex.MatchString(v.Regex) {
+		v.dynamic = newRegexCache()
+	} else if v.Regex != "" {
+		re, err := regexp.Compile(v.Regex)
+		if err != nil {
+			return fmt.Errorf("validation %s: %v", v.Path, err)
+		}
+		v.regex = re
+	}
+	return nil
+}
+
+// check returns an error if the constraints of v contradict each
+// other, so that no present value could pass.
+func (v *Validation) check() error {
+	if v.Min != nil && v.Max != nil && *v.Min > *v.Max {
+		return fmt.Errorf("validation %s: min %v is greater than max %v", v.Path, *v.Min, *v.Max)
+	}
+	if (v.MinLength != nil && *v.MinLength < 0) || (v.MaxLength != nil && *v.MaxLength < 0) {
+		return fmt.Errorf("validation %s: negative length", v.Path)
+	}
+	if v.MinLength != nil && v.MaxLength != nil && *v.MinLength > *v.MaxLength {
+		return fmt.Errorf("validation %s: minlen %d is greater than maxlen %d", v.Path, *v.MinLength, *v.MaxLength)
+	}
+	if v.regex != nil && len(v.Values) > 0 {
+		var ok bool
+		for _, val := range v.Values {
+			ok = ok || v.regex.MatchString(val)
+		}
+		if !ok {
+			return fmt.Errorf("validation %s: no allowed value matches %s", v.Path, v.Regex)
+		}
+	}
+	return nil
+}
+
+// validate returns an error describing why doc fails the validation,
+// or nil if it passes.
+// If nullPresent is set, json null counts as a value rather
+// than a missing one.
+func (v *Validation) validate(ctx context.Context, repl *caddy.Replacer, doc interface{}, nullPresent bool) error {
+	re := v.regex
+	if v.dynamic != nil {
+		var err error
+		re, err = v.dynamic.compile(repl.ReplaceKnown(v.Regex, ""))
+		if err != nil {
+			return fmt.Errorf("validation %s: %v", v.Path, err)
+		}
+	}
+
+	for _, val := range fetchValues(doc, v.Path) {
+		if val == nil && !nullPresent {
+			val = missing
+		}
+		if err := v.validateValue(ctx, re, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Validation) validateValue(ctx context.Context, re *regexp.Regexp, val interface{}) error {
+	if val == missing {
+		if v.Required {
+			return fmt.Errorf("missing required field %s", v.Path)
+		}
+		return nil
+	}
+
+	if re != nil {
+		s, ok := scalarString(val)
+		if !ok || !re.MatchString(s) {
+			return fmt.Errorf("%s does not match %s", v.Path, re)
+		}
+	}
+
+	if len(v.Values) > 0 {
+		s, ok := scalarString(val)
+		if !ok || !contains(v.Values, s) {
+			return fmt.Errorf("%s is not an allowed value", v.Path)
+		}
+	}
+
+	if len(v.AllowHosts) > 0 || v.DenyPrivate {
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("%s is not a url", v.Path)
+		}
+		if err := checkURL(ctx, s, v.AllowHosts, v.DenyPrivate); err != nil {
+			return fmt.Errorf("%s: %v", v.Path, err)
+		}
+	}
+
+	if v.Min != nil || v.Max != nil {
+		n, ok := number(val)
+		if !ok {
+			return fmt.Errorf("%s is not a number", v.Path)
+		}
+		if v.Min != nil && n < *v.Min {
+			return fmt.Errorf("%s is less than %v", v.Path, *v.Min)
+		}
+		if v.Max != nil && n > *v.Max {
+			return fmt.Errorf("%s is greater than %v", v.Path, *v.Max)
+		}
+	}
+
+	if v.MinLength != nil || v.MaxLength != nil {
+		n, ok := length(val)
+		if !ok {
+			return fmt.Errorf("%s is not a string or array", v.Path)
+		}
+		if v.MinLength != nil && n < *v.MinLength {
+			return fmt.Errorf("%s is shorter than %d", v.Path, *v.MinLength)
+		}
+		if v.MaxLength != nil && n > *v.MaxLength {
+			return fmt.Errorf("%s is longer than %d", v.Path, *v.MaxLength)
+		}
+	}
+
+	return nil
+}
+
+// number returns the value of a json number.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// length returns the number of characters of a string
+// or the number of elements of an array.
+func length(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case string:
+		return utf8.RuneCountInString(v), true
+	case []interface{}:
+		return len(v), true
+	}
+	return 0, false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fail returns the error rejecting a request that failed
+// the validation with err.
+func (v *Validation) fail(repl *caddy.Replacer, err error) error {
+	status := v.StatusCode
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	if v.Message != "" {
+		err = errors.New(repl.ReplaceAll(v.Message, ""))
+	}
+	return caddyhttp.Error(status, err)
+}
+
+// scalarString returns the text of a string, number or boolean value.
+func scalarString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
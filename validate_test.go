@@ -0,0 +1,97 @@
+package jsonparse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestValidation(t *testing.T) {
+	const body = `{"ref": "refs/heads/master", "id": 42, "tags": ["a"], "items": [{"id": 1, "url": "https://a"}, {"url": "http://b"}], "none": null}`
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	repl := caddy.NewReplacer()
+	repl.Set("vars.pattern", "^refs/heads/")
+
+	tests := []struct {
+		validation  Validation
+		constraints []string
+		path        string
+		nullPresent bool
+		valid       bool
+	}{
+		{validation: Validation{Path: "ref", Regex: "^refs/heads/"}, valid: true},
+		{validation: Validation{Path: "ref", Regex: "^refs/tags/"}, valid: false},
+		{validation: Validation{Path: "id", Regex: `^\d+$`}, valid: true},
+		{validation: Validation{Path: "tags", Regex: ".*"}, valid: false},
+		{validation: Validation{Path: "missing", Regex: "^x$"}, valid: true},
+		{validation: Validation{Path: "items.*.url", Regex: "^https?://"}, valid: true},
+		{validation: Validation{Path: "items.*.url", Regex: "^https://"}, valid: false},
+		{validation: Validation{Path: "ref", Required: true}, valid: true},
+		{validation: Validation{Path: "missing", Required: true}, valid: false},
+		{validation: Validation{Path: "items.*.url", Required: true}, valid: true},
+		{validation: Validation{Path: "items.*.id", Required: true}, valid: false},
+		{validation: Validation{Path: "ref.*.id", Required: true}, valid: false},
+		{validation: Validation{Path: "ref", Values: []string{"refs/heads/master", "refs/heads/main"}}, valid: true},
+		{validation: Validation{Path: "ref", Values: []string{"refs/heads/main"}}, valid: false},
+		{validation: Validation{Path: "id", Values: []string{"42"}}, valid: true},
+		{validation: Validation{Path: "tags", Values: []string{"a"}}, valid: false},
+		{constraints: []string{"min=1", "max=100"}, path: "id", valid: true},
+		{constraints: []string{"max=10"}, path: "id", valid: false},
+		{constraints: []string{"min=43"}, path: "id", valid: false},
+		{constraints: []string{"min=0"}, path: "ref", valid: false},
+		{constraints: []string{"minlen=1", "maxlen=1"}, path: "tags", valid: true},
+		{constraints: []string{"maxlen=5"}, path: "ref", valid: false},
+		{constraints: []string{"maxlen=9"}, path: "items.*.url", valid: true},
+		{validation: Validation{Path: "ref", Regex: "{vars.pattern}master$"}, valid: true},
+		{validation: Validation{Path: "ref", Regex: "{vars.pattern}main$"}, valid: false},
+		{validation: Validation{Path: "id", Regex: `^\d{2}$`}, valid: true},
+		{validation: Validation{Path: "none", Required: true}, valid: false},
+		{validation: Validation{Path: "none", Required: true}, nullPresent: true, valid: true},
+		{validation: Validation{Path: "none", Regex: "^x$"}, valid: true},
+		{validation: Validation{Path: "none", Regex: "^x$"}, nullPresent: true, valid: false},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			v := tt.validation
+			if tt.constraints != nil {
+				v.Path = tt.path
+				if err := v.parseConstraints(tt.constraints); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := v.provision(); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.validate(context.Background(), repl, doc, tt.nullPresent); (err == nil) != tt.valid {
+				t.Errorf("want valid: %v, got: %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestValidationProvisionInvalid(t *testing.T) {
+	tests := []*Validation{
+		{},
+		{Path: "a..b"},
+		{Path: "a", Regex: "("},
+		{Path: "a", StatusCode: 42},
+		{Path: "a", StatusCode: 1000},
+	}
+
+	for i, v := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			if err := v.provision(); err == nil {
+				t.Error("want error, got nil")
+			}
+		})
+	}
+}
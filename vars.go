@@ -0,0 +1,52 @@
+package jsonparse
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// varsPrefix is the name of the http variable holding the parsed
+// body, and the prefix of the variables holding its values.
+const varsPrefix = "json_parse"
+
+// Document returns a copy of the parsed request body, if a
+// json_parse handler earlier in the chain parsed it.
+func Document(r *http.Request) (interface{}, bool) {
+	doc, ok := r.Context().Value(documentCtxKey).(*document)
+	if !ok || doc.err != nil {
+		return nil, false
+	}
+	return deepCopy(doc.value), true
+}
+
+// setVars publishes a copy of v as the json_parse http variable,
+// and each value in it as json_parse.<path>, e.g. for the vars
+// matcher and the map directive.
+func setVars(ctx context.Context, v interface{}) {
+	v = deepCopy(v)
+	caddyhttp.SetVar(ctx, varsPrefix, v)
+	flatten(varsPrefix, v, func(key string, val interface{}) {
+		caddyhttp.SetVar(ctx, key, val)
+	})
+}
+
+// flatten calls set for every value nested in v with its
+// path joined to prefix.
+func flatten(prefix string, v interface{}, set func(string, interface{})) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			set(prefix+"."+key, val)
+			flatten(prefix+"."+key, val, set)
+		}
+	case []interface{}:
+		for i, val := range v {
+			key := prefix + "." + strconv.Itoa(i)
+			set(key, val)
+			flatten(key, val, set)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package jsonparse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestSetVars(t *testing.T) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"user":{"id":7,"roles":["admin"]},"ok":true}`), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), caddyhttp.VarsCtxKey, map[string]interface{}{})
+	setVars(ctx, v)
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "json_parse.user.id", want: "7"},
+		{key: "json_parse.user.roles.0", want: "admin"},
+		{key: "json_parse.ok", want: "true"},
+		{key: "json_parse.user.roles", want: "[admin]"},
+		{key: "json_parse.missing", want: "<nil>"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			got := fmt.Sprint(caddyhttp.GetVar(ctx, tt.key))
+			if got != tt.want {
+				t.Errorf("want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+
+	// the variables are copies of the parsed body
+	caddyhttp.GetVar(ctx, "json_parse.user").(map[string]interface{})["id"] = 8.0
+	if id := v.(map[string]interface{})["user"].(map[string]interface{})["id"]; id != 7.0 {
+		t.Errorf("want: %v, got: %v", 7, id)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	if _, ok := Document(r); ok {
+		t.Error("want no document")
+	}
+
+	doc := &document{value: map[string]interface{}{"a": 1.0}}
+	r = r.WithContext(context.WithValue(r.Context(), documentCtxKey, doc))
+	v, ok := Document(r)
+	if !ok {
+		t.Fatal("want document")
+	}
+	v.(map[string]interface{})["a"] = 2.0
+	if a := doc.value.(map[string]interface{})["a"]; a != 1.0 {
+		t.Errorf("want: %v, got: %v", 1, a)
+	}
+}